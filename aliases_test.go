@@ -0,0 +1,156 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/outofforest/build/v2/pkg/types"
+	"github.com/outofforest/logger"
+)
+
+var aliasCommands = map[string]types.Command{
+	"build/app": {Description: "Builds the application", Aliases: []string{"app/build"}},
+	"deploy": {
+		Description: "Deploys everything",
+		Deprecated:  "use deploy/app instead",
+		Flags:       []types.Flag{{Name: "env", Default: "dev"}},
+		Aliases:     []string{"release"},
+	},
+	"deploy/app":  {Description: "Deploys the application"},
+	"tools/setup": {Description: "Sets up the tools", Hidden: true},
+}
+
+func TestAliasExecutesCommand(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	ctx := logger.WithLogger(context.Background(), zap.New(core))
+
+	var env string
+	commands := map[string]types.Command{
+		"deploy": {
+			Aliases:    []string{"release"},
+			Deprecated: "use deploy/app instead",
+			Flags:      []types.Flag{{Name: "env", Default: "dev"}},
+			Fn: func(ctx context.Context, _ types.DepsFunc) error {
+				env = StringFlag(ctx, "env")
+				return nil
+			},
+		},
+	}
+
+	paths, commandFlags, err := parseArgs(globalFlags(), commands, []string{"release", "--env=staging"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"release"}, paths)
+
+	require.NoError(t, execute(ctx, commands, paths, executorConfig{Jobs: 1, Flags: commandFlags}))
+	assert.Equal(t, "staging", env)
+
+	entries := logs.AllUntimed()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "Command has been renamed, use the new path", entries[0].Message)
+	assert.Equal(t, map[string]any{"alias": "release", "command": "deploy"}, entries[0].ContextMap())
+	assert.Equal(t, "Command is deprecated", entries[1].Message)
+	assert.Equal(t, map[string]any{"command": "deploy", "message": "use deploy/app instead"},
+		entries[1].ContextMap())
+}
+
+func TestDeprecatedDependencyIsReported(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	ctx := logger.WithLogger(context.Background(), zap.New(core))
+
+	require.NoError(t, execute(ctx, map[string]types.Command{
+		"a":    {Fn: cmdA},
+		"a/aa": {Fn: cmdAA, Deprecated: "use b instead"},
+	}, []string{"a"}, executorConfig{Jobs: 1}))
+
+	entries := logs.AllUntimed()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "Command is deprecated", entries[0].Message)
+	assert.Equal(t, map[string]any{"command": "a/aa", "message": "use b instead"}, entries[0].ContextMap())
+}
+
+func TestHiddenCommands(t *testing.T) {
+	buf := &bytes.Buffer{}
+	listCommands(buf, aliasCommands, "")
+	assert.Equal(t, `
+ Available commands:
+
+   build/app   Builds the application
+   completion  Prints the shell completion script: bash, zsh or fish
+   deploy      Deploys everything
+   deploy/app  Deploys the application
+   help        Prints help of the command
+
+`, buf.String())
+
+	_, ok := subtreePrefix(aliasCommands, []string{"tools/"})
+	assert.False(t, ok)
+
+	assert.Empty(t, completions(tCtx, globalFlags(), aliasCommands, []string{"tool"}))
+	assert.Empty(t, completions(tCtx, globalFlags(), aliasCommands, []string{"app"}))
+
+	var executed bool
+	require.NoError(t, execute(tCtx, map[string]types.Command{
+		"tools/setup": {Hidden: true, Fn: func(_ context.Context, _ types.DepsFunc) error {
+			executed = true
+			return nil
+		}},
+	}, []string{"tools/setup"}, executorConfig{Jobs: 1}))
+	assert.True(t, executed)
+}
+
+func TestHelpOfAlias(t *testing.T) {
+	buf := &bytes.Buffer{}
+	require.NoError(t, help(buf, aliasCommands, []string{"release"}))
+	assert.Equal(t, `
+ deploy - Deploys everything
+
+ Flags:
+
+       --env string    (default "dev")
+
+ Aliases: release
+
+ Deprecated: use deploy/app instead
+
+ Available commands:
+
+   deploy/app  Deploys the application
+
+`, buf.String())
+}
+
+func TestRegisteringCollidingAliasFails(t *testing.T) {
+	registry := newCommandRegistry()
+	require.NoError(t, registry.RegisterCommands([]map[string]types.Command{
+		{
+			"build": {Aliases: []string{"compile"}},
+		},
+	}))
+
+	err := registry.RegisterCommands([]map[string]types.Command{{"test": {Aliases: []string{"build"}}}})
+	require.EqualError(t, err, "command build has already been registered")
+
+	err = registry.RegisterCommands([]map[string]types.Command{{"test": {Aliases: []string{"compile"}}}})
+	require.EqualError(t, err, "command compile has already been registered")
+
+	err = registry.RegisterCommands([]map[string]types.Command{{"compile": {}}})
+	require.EqualError(t, err, "command compile has already been registered")
+
+	err = registry.RegisterCommands([]map[string]types.Command{{"test": {Aliases: []string{"help"}}}})
+	require.EqualError(t, err, "command help is reserved")
+
+	err = registry.RegisterCommands([]map[string]types.Command{
+		{
+			"test":  {Aliases: []string{"check"}},
+			"check": {},
+		},
+	})
+	require.EqualError(t, err, "command check has already been registered")
+}
@@ -0,0 +1,557 @@
+package build
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/archive"
+	"github.com/outofforest/build/v2/pkg/tools"
+	"github.com/outofforest/build/v2/pkg/types"
+)
+
+const defaultCacheMaxSize = 10 * 1024 * 1024 * 1024
+
+// cacheKey computes the key identifying outputs of the command, based on its inputs, identity and versions of tools.
+func cacheKey(ctx context.Context, path string, cmd types.Command) (string, error) {
+	inputsHash, err := inputsFingerprint(cmd.Inputs)
+	if err != nil {
+		return "", err
+	}
+
+	hasher := sha256.New()
+	fmt.Fprintf(hasher, "command %s\n", path)
+	fmt.Fprintf(hasher, "inputs %s\n", inputsHash)
+	for _, output := range cmd.Outputs {
+		fmt.Fprintf(hasher, "output %s\n", filepath.ToSlash(filepath.Clean(output)))
+	}
+	fmt.Fprintf(hasher, "flags %q\n", flagsFingerprint(ctx, cmd))
+	fmt.Fprintf(hasher, "version %s\n", tools.GetVersion(ctx))
+	for _, tool := range tools.List() {
+		fmt.Fprintf(hasher, "tool %s %s\n", tool.GetName(), tool.GetVersion())
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func cleanCache(ctx context.Context, _ types.DepsFunc) error {
+	if IsDryRun(ctx) {
+		return nil
+	}
+	return newLocalCache(cacheDir(ctx), defaultCacheMaxSize).Clean()
+}
+
+func printCacheStats(ctx context.Context, _ types.DepsFunc) error {
+	entries, size, err := newLocalCache(cacheDir(ctx), defaultCacheMaxSize).Stats()
+	if err != nil {
+		return err
+	}
+
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "\n Cache stored in %s:\n", cacheDir(ctx))
+	fmt.Fprintln(buf)
+	fmt.Fprintf(buf, "   Entries:   %d\n", entries)
+	fmt.Fprintf(buf, "   Size:      %s\n", formatSize(size))
+	fmt.Fprintf(buf, "   Max size:  %s\n", formatSize(defaultCacheMaxSize))
+	fmt.Fprintln(buf, "")
+	_, err = io.WriteString(rawStdout(ctx), buf.String())
+	return errors.WithStack(err)
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+func cacheDir(ctx context.Context) string {
+	return filepath.Join(tools.EnvDir(ctx), "cache")
+}
+
+func newLocalCache(dir string, maxSize int64) *localCache {
+	return &localCache{
+		dir:     dir,
+		maxSize: maxSize,
+	}
+}
+
+// localCache stores outputs of commands in the local directory.
+// Action directory maps cache keys to hashes of the content, content directory stores archived outputs
+// named by their hashes.
+type localCache struct {
+	dir     string
+	maxSize int64
+
+	mu sync.Mutex
+}
+
+// Restore restores outputs stored under the key. False is returned if they are not in the cache.
+func (c *localCache) Restore(key string, outputs []string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	checksum, exists, err := c.action(key)
+	if err != nil || !exists {
+		return false, err
+	}
+
+	f, exists, err := c.openContent(checksum)
+	if err != nil || !exists {
+		return false, err
+	}
+	defer f.Close()
+
+	if err := extractOutputs(f, outputs); err != nil {
+		return false, err
+	}
+
+	// Modification time is used to find the least recently used entries.
+	now := time.Now()
+	return true, errors.WithStack(os.Chtimes(f.Name(), now, now))
+}
+
+// Store stores outputs under the key and returns the checksum of the stored content.
+func (c *localCache) Store(key string, outputs []string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	checksum, err := c.storeContent(func(w io.Writer) error {
+		return archiveOutputs(w, outputs)
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := c.storeAction(key, checksum); err != nil {
+		return "", err
+	}
+	return checksum, c.evict()
+}
+
+// Import stores the content received from another cache under the key.
+// Checksum of the content is verified before storing it.
+func (c *localCache) Import(key, checksum string, r io.Reader) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.importContent(checksum, r); err != nil {
+		return err
+	}
+	if err := c.storeAction(key, checksum); err != nil {
+		return err
+	}
+	return c.evict()
+}
+
+// Action returns the checksum of the content stored under the key.
+func (c *localCache) Action(key string) (string, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.action(key)
+}
+
+// StoreAction stores the checksum of the content under the key.
+func (c *localCache) StoreAction(key, checksum string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.storeAction(key, checksum)
+}
+
+// OpenContent opens the content identified by the checksum.
+func (c *localCache) OpenContent(checksum string) (*os.File, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.openContent(checksum)
+}
+
+// ImportContent stores the content, after verifying its checksum.
+func (c *localCache) ImportContent(checksum string, r io.Reader) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.importContent(checksum, r); err != nil {
+		return err
+	}
+	return c.evict()
+}
+
+func (c *localCache) action(key string) (string, bool, error) {
+	checksum, err := os.ReadFile(c.actionFile(key))
+	switch {
+	case err == nil:
+		return string(checksum), true, nil
+	case errors.Is(err, os.ErrNotExist):
+		return "", false, nil
+	default:
+		return "", false, errors.WithStack(err)
+	}
+}
+
+func (c *localCache) storeAction(key, checksum string) error {
+	return writeFileAtomically(c.actionFile(key), []byte(checksum))
+}
+
+func (c *localCache) openContent(checksum string) (*os.File, bool, error) {
+	f, err := os.Open(c.contentFile(checksum))
+	switch {
+	case err == nil:
+		return f, true, nil
+	case errors.Is(err, os.ErrNotExist):
+		return nil, false, nil
+	default:
+		return nil, false, errors.WithStack(err)
+	}
+}
+
+func (c *localCache) importContent(checksum string, r io.Reader) error {
+	reader, err := archive.NewHashingReader(r, checksum)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = c.storeContent(func(w io.Writer) error {
+		if _, err := io.Copy(w, reader); err != nil {
+			return errors.WithStack(err)
+		}
+		return errors.Wrapf(reader.ValidateChecksum(), "verifying checksum of cache content %s failed", checksum)
+	})
+	return err
+}
+
+// storeContent stores the content produced by the function, in the file named by its checksum.
+func (c *localCache) storeContent(fn func(w io.Writer) error) (string, error) {
+	contentDir := filepath.Join(c.dir, "content")
+	if err := os.MkdirAll(contentDir, 0o700); err != nil {
+		return "", errors.WithStack(err)
+	}
+	f, err := os.CreateTemp(contentDir, ".tmp-*")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	hasher := sha256.New()
+	if err := fn(io.MultiWriter(f, hasher)); err != nil {
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	checksum := "sha256:" + hex.EncodeToString(hasher.Sum(nil))
+	if err := os.Rename(f.Name(), c.contentFile(checksum)); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return checksum, nil
+}
+
+// Stats returns the number of entries and the total size of the cache.
+func (c *localCache) Stats() (int, int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := c.contentEntries()
+	if err != nil {
+		return 0, 0, err
+	}
+	var size int64
+	for _, entry := range entries {
+		size += entry.Size()
+	}
+	return len(entries), size, nil
+}
+
+// Clean removes all the entries from the cache.
+func (c *localCache) Clean() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return errors.WithStack(os.RemoveAll(c.dir))
+}
+
+// evict removes the least recently used entries until the size of the cache fits the limit.
+func (c *localCache) evict() error {
+	entries, err := c.contentEntries()
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	for _, entry := range entries {
+		size += entry.Size()
+	}
+	if size <= c.maxSize {
+		return nil
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ModTime().Before(entries[j].ModTime())
+	})
+	for _, entry := range entries {
+		if size <= c.maxSize {
+			break
+		}
+		if err := os.Remove(filepath.Join(c.dir, "content", entry.Name())); err != nil &&
+			!errors.Is(err, os.ErrNotExist) {
+			return errors.WithStack(err)
+		}
+		size -= entry.Size()
+	}
+
+	// Actions pointing to the removed content are not needed anymore.
+	actions, err := os.ReadDir(filepath.Join(c.dir, "action"))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, action := range actions {
+		actionFile := filepath.Join(c.dir, "action", action.Name())
+		checksum, err := os.ReadFile(actionFile)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if _, err := os.Stat(c.contentFile(string(checksum))); errors.Is(err, os.ErrNotExist) {
+			if err := os.Remove(actionFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return errors.WithStack(err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *localCache) contentEntries() ([]fs.FileInfo, error) {
+	dirEntries, err := os.ReadDir(filepath.Join(c.dir, "content"))
+	switch {
+	case err == nil:
+	case errors.Is(err, os.ErrNotExist):
+		return nil, nil
+	default:
+		return nil, errors.WithStack(err)
+	}
+
+	entries := make([]fs.FileInfo, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if strings.HasPrefix(dirEntry.Name(), ".") {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		entries = append(entries, info)
+	}
+	return entries, nil
+}
+
+func (c *localCache) actionFile(key string) string {
+	return filepath.Join(c.dir, "action", key)
+}
+
+func (c *localCache) contentFile(checksum string) string {
+	return filepath.Join(c.dir, "content", strings.TrimPrefix(checksum, "sha256:"))
+}
+
+func writeFileAtomically(file string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return errors.WithStack(err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(file), ".tmp-*")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := f.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(f.Name(), file))
+}
+
+// archiveOutputs writes outputs to the tar archive.
+func archiveOutputs(w io.Writer, outputs []string) error {
+	tw := tar.NewWriter(w)
+	for _, output := range outputs {
+		err := filepath.WalkDir(output, func(path string, d fs.DirEntry, err error) error {
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+				return nil
+			case err != nil:
+				return errors.WithStack(err)
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			var link string
+			if info.Mode()&fs.ModeSymlink != 0 {
+				if link, err = os.Readlink(path); err != nil {
+					return errors.WithStack(err)
+				}
+				if !isLocalLink(link) {
+					return errors.Errorf("symlink %s points outside of outputs: %s", path, link)
+				}
+			}
+			header, err := tar.FileInfoHeader(info, link)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			header.Name = filepath.ToSlash(path)
+			if err := tw.WriteHeader(header); err != nil {
+				return errors.WithStack(err)
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			defer f.Close()
+
+			_, err = io.Copy(tw, f)
+			return errors.WithStack(err)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return errors.WithStack(tw.Close())
+}
+
+// extractOutputs replaces outputs with the ones stored in the tar archive.
+func extractOutputs(r io.Reader, outputs []string) error {
+	for _, output := range outputs {
+		if err := os.RemoveAll(output); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		switch {
+		case err == nil:
+		case errors.Is(err, io.EOF):
+			return nil
+		default:
+			return errors.WithStack(err)
+		}
+
+		path := filepath.Clean(filepath.FromSlash(header.Name))
+		root, ok := outputRoot(path, outputs)
+		if !ok {
+			return errors.Errorf("file %s is not an output", header.Name)
+		}
+		if err := ensureNoSymlinks(root, path); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return errors.WithStack(err)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, header.FileInfo().Mode().Perm()); err != nil {
+				return errors.WithStack(err)
+			}
+		case tar.TypeSymlink:
+			if !isLocalLink(header.Linkname) {
+				return errors.Errorf("symlink %s points outside of outputs: %s", header.Name, header.Linkname)
+			}
+			if err := os.Symlink(header.Linkname, path); err != nil {
+				return errors.WithStack(err)
+			}
+		case tar.TypeReg:
+			if err := extractFile(path, tr, header.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+		default:
+			return errors.Errorf("unsupported file type %c of %s", header.Typeflag, header.Name)
+		}
+	}
+}
+
+func extractFile(path string, r io.Reader, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, r)
+	return errors.WithStack(err)
+}
+
+// outputRoot returns the output containing the path.
+func outputRoot(path string, outputs []string) (string, bool) {
+	for _, output := range outputs {
+		output = filepath.Clean(output)
+		if path == output || strings.HasPrefix(path, output+string(filepath.Separator)) {
+			return output, true
+		}
+	}
+	return "", false
+}
+
+// ensureNoSymlinks returns error if any component of the path below the output root is a symlink,
+// so the file is not written outside of outputs.
+func ensureNoSymlinks(root, path string) error {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	current := root
+	for _, part := range append([]string{"."}, strings.Split(rel, string(filepath.Separator))...) {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			return nil
+		case err != nil:
+			return errors.WithStack(err)
+		case info.Mode()&os.ModeSymlink != 0:
+			return errors.Errorf("file %s is written through symlink %s", path, current)
+		}
+	}
+	return nil
+}
+
+// isLocalLink returns true if target of the symlink is relative and does not refer to parent directories.
+func isLocalLink(target string) bool {
+	if filepath.IsAbs(target) || strings.HasPrefix(target, "/") {
+		return false
+	}
+	for _, part := range strings.Split(filepath.ToSlash(target), "/") {
+		if part == ".." {
+			return false
+		}
+	}
+	return true
+}
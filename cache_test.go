@@ -0,0 +1,190 @@
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/outofforest/build/v2/pkg/tools"
+	"github.com/outofforest/build/v2/pkg/types"
+	"github.com/outofforest/logger"
+)
+
+func TestOutputsAreRestoredFromCache(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Chdir(t.TempDir())
+	ctx := tools.WithVersion(tools.WithName(tCtx, "test"), "v1")
+
+	var executed int
+	commands := map[string]types.Command{
+		"cmd": {
+			Fn: func(_ context.Context, _ types.DepsFunc) error {
+				executed++
+				input, err := os.ReadFile("input.txt")
+				if err != nil {
+					return err
+				}
+				if err := os.MkdirAll("bin", 0o700); err != nil {
+					return err
+				}
+				return os.WriteFile(filepath.Join("bin", "app"), input, 0o700)
+			},
+			Inputs:  []string{"input.txt"},
+			Outputs: []string{"bin"},
+		},
+	}
+	exe := func(input string) {
+		require.NoError(t, os.WriteFile("input.txt", []byte(input), 0o600))
+		require.NoError(t, execute(ctx, commands, []string{"cmd"}, executorConfig{Jobs: 1}))
+	}
+
+	exe("v1")
+	assert.Equal(t, 1, executed)
+
+	exe("v2")
+	assert.Equal(t, 2, executed)
+
+	exe("v1")
+	assert.Equal(t, 2, executed)
+	output, err := os.ReadFile(filepath.Join("bin", "app"))
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(output))
+	info, err := os.Stat(filepath.Join("bin", "app"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+
+	entries, _, err := newLocalCache(cacheDir(ctx), defaultCacheMaxSize).Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 2, entries)
+}
+
+func TestOutputsWithSymlinksOutsideOutputsAreNotCached(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Chdir(t.TempDir())
+	core, logs := observer.New(zapcore.WarnLevel)
+	ctx := logger.WithLogger(tools.WithVersion(tools.WithName(tCtx, "test"), "v1"), zap.New(core))
+
+	var executed int
+	commands := map[string]types.Command{
+		"cmd": {
+			Fn: func(_ context.Context, _ types.DepsFunc) error {
+				executed++
+				if err := os.MkdirAll("bin", 0o700); err != nil {
+					return err
+				}
+				return os.Symlink("/etc/passwd", filepath.Join("bin", "link"))
+			},
+			Inputs:  []string{"input.txt"},
+			Outputs: []string{"bin"},
+		},
+	}
+	require.NoError(t, os.WriteFile("input.txt", nil, 0o600))
+
+	require.NoError(t, execute(ctx, commands, []string{"cmd"}, executorConfig{Jobs: 1}))
+	require.NoError(t, execute(ctx, commands, []string{"cmd"}, executorConfig{Jobs: 1}))
+	assert.Equal(t, 1, executed)
+
+	assert.Equal(t, 1, logs.FilterMessage("Storing outputs in cache failed").Len())
+	entries, _, err := newLocalCache(cacheDir(ctx), defaultCacheMaxSize).Stats()
+	require.NoError(t, err)
+	assert.Zero(t, entries)
+}
+
+func TestCacheEvictsLeastRecentlyUsedEntries(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cache := newLocalCache(filepath.Join(t.TempDir(), "cache"), defaultCacheMaxSize)
+
+	store := func(key string, mtime time.Time) {
+		require.NoError(t, os.WriteFile("output", []byte(key), 0o600))
+		checksum, err := cache.Store(key, []string{"output"})
+		require.NoError(t, err)
+		require.NoError(t, os.Chtimes(cache.contentFile(checksum), mtime, mtime))
+	}
+
+	now := time.Now()
+	store("a", now.Add(-3*time.Hour))
+
+	// Cache is limited to three entries.
+	_, size, err := cache.Stats()
+	require.NoError(t, err)
+	cache.maxSize = 3 * size
+
+	store("b", now.Add(-time.Hour))
+	store("c", now.Add(-2*time.Hour))
+
+	restored, err := cache.Restore("a", []string{"output"})
+	require.NoError(t, err)
+	assert.True(t, restored)
+
+	store("d", now)
+
+	for key, expected := range map[string]bool{"a": true, "b": true, "c": false, "d": true} {
+		restored, err := cache.Restore(key, []string{"output"})
+		require.NoError(t, err)
+		assert.Equal(t, expected, restored, key)
+	}
+}
+
+func TestExtractingFilesOutsideOutputsFails(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{
+		Name:     "bin/../../file",
+		Typeflag: tar.TypeReg,
+		Mode:     0o600,
+	}))
+	require.NoError(t, tw.Close())
+
+	require.Error(t, extractOutputs(buf, []string{"bin"}))
+}
+
+func TestExtractingSymlinksOutsideOutputsFails(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	for _, target := range []string{"/tmp", "../..", "dir/../../x"} {
+		buf := &bytes.Buffer{}
+		tw := tar.NewWriter(buf)
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     "bin/link",
+			Linkname: target,
+			Typeflag: tar.TypeSymlink,
+		}))
+		require.NoError(t, tw.Close())
+
+		require.Error(t, extractOutputs(buf, []string{"bin"}), target)
+	}
+}
+
+func TestExtractingFilesThroughSymlinksFails(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{
+		Name:     "bin/link",
+		Linkname: "dir",
+		Typeflag: tar.TypeSymlink,
+	}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{
+		Name:     "bin/link/file",
+		Typeflag: tar.TypeReg,
+		Mode:     0o600,
+	}))
+	require.NoError(t, tw.Close())
+
+	require.EqualError(t, extractOutputs(buf, []string{"bin"}),
+		"file bin/link/file is written through symlink bin/link")
+	assert.NoFileExists(t, filepath.Join("bin", "dir", "file"))
+}
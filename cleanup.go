@@ -0,0 +1,51 @@
+package build
+
+import (
+	"context"
+	goerrors "errors"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const cleanupTimeout = time.Minute
+
+// Defer registers the function executed once all the commands complete, even if any of them fails or the build
+// is canceled. It is used to clean up resources like containers or temporary servers started by the command.
+// Functions are executed in reverse order, each of them receives fresh context canceled after one minute.
+func Defer(ctx context.Context, fn func(ctx context.Context) error) {
+	e := getExecutor(ctx)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.deferred = append(e.deferred, fn)
+}
+
+// runDeferred executes functions registered by `Defer` and returns their errors.
+func (e *executor) runDeferred(ctx context.Context) error {
+	e.mu.Lock()
+	deferred := e.deferred
+	e.deferred = nil
+	e.mu.Unlock()
+
+	ctx = context.WithoutCancel(ctx)
+	errs := make([]error, 0, len(deferred))
+	for i := len(deferred) - 1; i >= 0; i-- {
+		errs = append(errs, runCleanup(ctx, deferred[i]))
+	}
+	return goerrors.Join(errs...)
+}
+
+func runCleanup(ctx context.Context, fn func(ctx context.Context) error) (retErr error) {
+	ctx, cancel := context.WithTimeout(ctx, cleanupTimeout)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			retErr = errors.Errorf("cleanup panicked: %v", r)
+		}
+	}()
+
+	return fn(ctx)
+}
@@ -0,0 +1,79 @@
+package build
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/outofforest/build/v2/pkg/types"
+)
+
+func TestDeferredFunctionsAreExecutedInReverseOrder(t *testing.T) {
+	r = &recorder{}
+	err := execute(tCtx, map[string]types.Command{
+		"a": {Fn: func(ctx context.Context, deps types.DepsFunc) error {
+			Defer(ctx, func(ctx context.Context) error {
+				r.Record("cleanup 1")
+				return nil
+			})
+			Defer(ctx, func(ctx context.Context) error {
+				r.Record("cleanup 2")
+				return nil
+			})
+			r.Record("a")
+			return nil
+		}},
+	}, []string{"a"}, executorConfig{Jobs: 4})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "cleanup 2", "cleanup 1"}, r.Records())
+}
+
+func TestDeferredFunctionsAreExecutedOnFailure(t *testing.T) {
+	errCmd := errors.New("command failed")
+	errCleanup := errors.New("cleanup failed")
+
+	var cleanupCtxErr error
+	err := execute(tCtx, map[string]types.Command{
+		"a": {Fn: func(ctx context.Context, deps types.DepsFunc) error {
+			Defer(ctx, func(ctx context.Context) error {
+				cleanupCtxErr = ctx.Err()
+				return errCleanup
+			})
+			Defer(ctx, func(ctx context.Context) error {
+				panic("panic")
+			})
+			return errCmd
+		}},
+	}, []string{"a"}, executorConfig{Jobs: 4})
+
+	require.ErrorIs(t, err, errCmd)
+	require.ErrorIs(t, err, errCleanup)
+	require.ErrorContains(t, err, "cleanup panicked: panic")
+	require.NoError(t, cleanupCtxErr)
+}
+
+func TestDeferredFunctionsAreExecutedOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(tCtx)
+
+	var cleanupCtxErr error
+	var cleaned bool
+	err := execute(ctx, map[string]types.Command{
+		"a": {Fn: func(ctx context.Context, deps types.DepsFunc) error {
+			Defer(ctx, func(ctx context.Context) error {
+				cleaned = true
+				cleanupCtxErr = ctx.Err()
+				return nil
+			})
+			cancel()
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+	}, []string{"a"}, executorConfig{Jobs: 4})
+
+	require.ErrorIs(t, err, context.Canceled)
+	assert.True(t, cleaned)
+	require.NoError(t, cleanupCtxErr)
+}
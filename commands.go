@@ -21,10 +21,16 @@ var Commands = map[string]types.Command{
 	"enter": {
 		Description: "Enters the environment",
 		Fn:          enter,
+		Interactive: true,
 	},
 	"build/me": {
 		Description: "Rebuilds the builder",
 		Fn: func(ctx context.Context, deps types.DepsFunc) error {
+			if IsDryRun(ctx) {
+				// Dependencies of golang.Build are declared, so they are present in the execution plan.
+				deps(golang.EnsureGo)
+				return nil
+			}
 			return golang.Build(ctx, deps, golang.BuildConfig{
 				Platform:      tools.PlatformLocal,
 				PackagePath:   "build/cmd/builder",
@@ -32,6 +38,36 @@ var Commands = map[string]types.Command{
 			})
 		},
 	},
+	"cache/clean": {
+		Description: "Removes all the outputs stored in the build cache",
+		Fn:          cleanCache,
+	},
+	"cache/serve": {
+		Description: "Runs the remote build cache server",
+		Fn:          serveCache,
+		Flags: []types.Flag{
+			{Name: "address", Default: "localhost:9092", Help: "Address the server listens on"},
+		},
+	},
+	"cache/stats": {
+		Description: "Prints statistics of the build cache",
+		Fn:          printCacheStats,
+	},
+	"graph": {
+		Description: "Prints the dependency graph of commands",
+		Fn:          printGraph,
+		Flags: []types.Flag{
+			{
+				Name:    "format",
+				Default: "dot",
+				Help:    "Format of the graph: dot, mermaid or json",
+				Complete: func(_ context.Context) ([]string, error) {
+					return []string{"dot", "mermaid", "json"}, nil
+				},
+			},
+			{Name: "target", Default: []string{}, Help: "Command the graph is printed for"},
+		},
+	},
 	"tools/setup": {
 		Description: "Installs all the tools for the host operating system",
 		Fn:          tools.EnsureAll,
@@ -43,6 +79,10 @@ var Commands = map[string]types.Command{
 }
 
 func enter(ctx context.Context, deps types.DepsFunc) error {
+	if IsDryRun(ctx) {
+		return nil
+	}
+
 	bash := exec.Command("bash")
 	bash.Env = append(os.Environ(),
 		"PS1=("+tools.GetName(ctx)+`) [\u@\h \W]\$ `,
@@ -0,0 +1,209 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+
+	"github.com/outofforest/build/v2/pkg/types"
+)
+
+const (
+	completionCommand = "completion"
+	completeCommand   = "__complete"
+)
+
+// completion is the candidate proposed to the user.
+type completion struct {
+	Value       string
+	Description string
+}
+
+// completions returns candidates for the last argument, previous arguments are the ones already typed.
+// Paths of commands, names of flags and values of flags are completed.
+func completions(
+	ctx context.Context,
+	globalFlags *pflag.FlagSet,
+	commands map[string]types.Command,
+	args []string,
+) []completion {
+	var current string
+	if len(args) > 0 {
+		current = args[len(args)-1]
+		args = args[:len(args)-1]
+	}
+
+	cmd, flags := completedCommand(globalFlags, commands, args)
+	switch {
+	case strings.HasPrefix(current, "-") && strings.Contains(current, "="):
+		name, prefix, _ := strings.Cut(current, "=")
+		return flagValueCompletions(ctx, cmd, strings.TrimLeft(name, "-"), prefix, name+"=")
+	case strings.HasPrefix(current, "-"):
+		return flagCompletions(flags, current)
+	case len(args) > 0:
+		if flag := lookupFlag(flags, args[len(args)-1]); flag != nil && flag.NoOptDefVal == "" {
+			return flagValueCompletions(ctx, cmd, flag.Name, current, "")
+		}
+	}
+	return pathCompletions(commands, current)
+}
+
+// completedCommand returns the last command present in the arguments and flags accepted by it.
+func completedCommand(
+	globalFlags *pflag.FlagSet,
+	commands map[string]types.Command,
+	args []string,
+) (types.Command, *pflag.FlagSet) {
+	for i := len(args) - 1; i >= 0; i-- {
+		path, cmd, exists := lookupCommand(commands, strings.TrimSuffix(args[i], "/"))
+		if !exists {
+			continue
+		}
+		flags, err := newFlagSet(path, cmd)
+		if err != nil {
+			break
+		}
+		flags.AddFlagSet(globalFlags)
+		return cmd, flags
+	}
+	return types.Command{}, globalFlags
+}
+
+// lookupFlag returns the flag specified by the argument, if it's the flag without value.
+func lookupFlag(flags *pflag.FlagSet, arg string) *pflag.Flag {
+	switch {
+	case strings.Contains(arg, "="):
+		return nil
+	case strings.HasPrefix(arg, "--"):
+		return flags.Lookup(arg[2:])
+	case strings.HasPrefix(arg, "-") && len(arg) == 2:
+		return flags.ShorthandLookup(arg[1:])
+	default:
+		return nil
+	}
+}
+
+func pathCompletions(commands map[string]types.Command, prefix string) []completion {
+	prefixDir := prefix[:strings.LastIndex(prefix, "/")+1]
+	result := []completion{}
+	for choice, children := range choicesForPrefix(visiblePaths(commands), prefix) {
+		value := prefixDir + choice
+		description := commands[value].Description
+		if children {
+			value += "/"
+		}
+		result = append(result, completion{Value: value, Description: description})
+	}
+	return sortCompletions(result)
+}
+
+// flagCompletions returns names of flags. Names of flags requiring value are followed by "=".
+func flagCompletions(flags *pflag.FlagSet, prefix string) []completion {
+	result := []completion{}
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if flag.Hidden {
+			return
+		}
+		value := "--" + flag.Name
+		if flag.NoOptDefVal == "" {
+			value += "="
+		}
+		if strings.HasPrefix(value, prefix) {
+			result = append(result, completion{Value: value, Description: flag.Usage})
+		}
+	})
+	return sortCompletions(result)
+}
+
+// flagValueCompletions returns values of the flag provided by its completion function.
+func flagValueCompletions(
+	ctx context.Context,
+	cmd types.Command,
+	name, prefix, valuePrefix string,
+) []completion {
+	result := []completion{}
+	for _, flag := range cmd.Flags {
+		if flag.Name != name || flag.Complete == nil {
+			continue
+		}
+		values, err := flag.Complete(ctx)
+		if err != nil {
+			break
+		}
+		for _, value := range values {
+			if strings.HasPrefix(value, prefix) {
+				result = append(result, completion{Value: valuePrefix + value})
+			}
+		}
+	}
+	return sortCompletions(result)
+}
+
+func sortCompletions(completions []completion) []completion {
+	sort.Slice(completions, func(i, j int) bool {
+		return completions[i].Value < completions[j].Value
+	})
+	return completions
+}
+
+// printCompletions prints candidates using shell-neutral protocol: one candidate per line,
+// followed by tab and its description. Candidates ending with "/" or "=" must not be followed by space.
+func printCompletions(w io.Writer, completions []completion) {
+	for _, c := range completions {
+		if c.Description == "" {
+			fmt.Fprintln(w, c.Value)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\n", c.Value, c.Description)
+	}
+}
+
+// printCompletionScript prints the script installing completion of the program in the shell.
+func printCompletionScript(w io.Writer, shell, name string) error {
+	var script string
+	switch shell {
+	case "bash":
+		script = bashCompletion
+	case "zsh":
+		script = zshCompletion
+	case "fish":
+		script = fishCompletion
+	default:
+		return errors.Errorf("build: completion for shell %q is not supported, use bash, zsh or fish", shell)
+	}
+	_, err := io.WriteString(w, strings.ReplaceAll(script, "{{name}}", name))
+	return errors.WithStack(err)
+}
+
+const bashCompletion = `complete -o nospace -C {{name}} {{name}}
+`
+
+const zshCompletion = `#compdef {{name}}
+
+_{{name}}() {
+  local -a dirs leaves
+  local value description
+  while IFS=$'\t' read -r value description; do
+    [[ -z "$value" ]] && continue
+    value=${value//:/\\:}
+    if [[ "$value" == */ || "$value" == *= ]]; then
+      dirs+=("${value}${description:+:$description}")
+    else
+      leaves+=("${value}${description:+:$description}")
+    fi
+  done < <({{name}} __complete "${(@)words[2,CURRENT]}" 2>/dev/null)
+
+  (( ${#dirs} )) && _describe -t dirs 'commands' dirs -S '' -Q
+  (( ${#leaves} )) && _describe -t commands 'commands' leaves -Q
+}
+
+compdef _{{name}} {{name}}
+`
+
+const fishCompletion = `complete -c {{name}} -f -a '({{name}} __complete (commandline -opc)[2..-1] (commandline -ct) 2>/dev/null)'
+`
@@ -0,0 +1,104 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/outofforest/build/v2/pkg/types"
+)
+
+var completionCommands = map[string]types.Command{
+	"build":     {Description: "Builds everything"},
+	"build/app": {Description: "Builds the application"},
+	"build/db":  {Description: "Builds the database"},
+	"deploy": {
+		Description: "Deploys everything",
+		Flags: []types.Flag{
+			{
+				Name:    "env",
+				Default: "dev",
+				Help:    "Environment",
+				Complete: func(_ context.Context) ([]string, error) {
+					return []string{"dev", "staging", "stable"}, nil
+				},
+			},
+			{Name: "wait", Default: false, Help: "Waits for the deployment"},
+		},
+	},
+	"test": {},
+}
+
+func TestCompletions(t *testing.T) {
+	assert.Equal(t, []completion{
+		{Value: "build/", Description: "Builds everything"},
+		{Value: "deploy", Description: "Deploys everything"},
+		{Value: "test"},
+	}, completions(tCtx, globalFlags(), completionCommands, nil))
+
+	assert.Equal(t, []completion{
+		{Value: "build/", Description: "Builds everything"},
+	}, completions(tCtx, globalFlags(), completionCommands, []string{"deploy", "b"}))
+
+	assert.Equal(t, []completion{
+		{Value: "build/app", Description: "Builds the application"},
+		{Value: "build/db", Description: "Builds the database"},
+	}, completions(tCtx, globalFlags(), completionCommands, []string{"build/"}))
+
+	assert.Empty(t, completions(tCtx, globalFlags(), completionCommands, []string{"x"}))
+}
+
+func TestFlagCompletions(t *testing.T) {
+	assert.Equal(t, []completion{
+		{Value: "--env=", Description: "Environment"},
+		{Value: "--jobs="},
+		{Value: "--wait", Description: "Waits for the deployment"},
+	}, completions(tCtx, globalFlags(), completionCommands, []string{"deploy", "--"}))
+
+	assert.Equal(t, []completion{
+		{Value: "--jobs="},
+	}, completions(tCtx, globalFlags(), completionCommands, []string{"test", "-"}))
+
+	assert.Equal(t, []completion{
+		{Value: "--env=stable"},
+		{Value: "--env=staging"},
+	}, completions(tCtx, globalFlags(), completionCommands, []string{"deploy", "--wait", "--env=st"}))
+
+	assert.Equal(t, []completion{
+		{Value: "dev"},
+		{Value: "stable"},
+		{Value: "staging"},
+	}, completions(tCtx, globalFlags(), completionCommands, []string{"deploy", "--env", ""}))
+
+	assert.Equal(t, []completion{
+		{Value: "build/", Description: "Builds everything"},
+	}, completions(tCtx, globalFlags(), completionCommands, []string{"deploy", "--wait", "b"}))
+
+	assert.Empty(t, completions(tCtx, globalFlags(), completionCommands, []string{"-j", ""}))
+}
+
+func TestPrintCompletions(t *testing.T) {
+	buf := &bytes.Buffer{}
+	printCompletions(buf, completions(tCtx, globalFlags(), completionCommands, []string{""}))
+	assert.Equal(t, "build/\tBuilds everything\ndeploy\tDeploys everything\ntest\n", buf.String())
+}
+
+func TestPrintCompletionScript(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		buf := &bytes.Buffer{}
+		require.NoError(t, printCompletionScript(buf, shell, "projname"))
+		assert.Contains(t, buf.String(), "projname")
+		assert.NotContains(t, buf.String(), "{{name}}")
+	}
+
+	require.Error(t, printCompletionScript(&bytes.Buffer{}, "tcsh", "projname"))
+}
+
+func TestReservedCommandsCantBeRegistered(t *testing.T) {
+	registry := newCommandRegistry()
+	require.EqualError(t, registry.RegisterCommands([]map[string]types.Command{{"completion": {}}}),
+		"command completion is reserved")
+}
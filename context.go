@@ -0,0 +1,52 @@
+package build
+
+import (
+	"context"
+
+	"github.com/spf13/pflag"
+
+	"github.com/outofforest/build/v2/pkg/tools"
+)
+
+type executorFieldType int
+
+type flagsFieldType int
+
+type outputFieldType int
+
+const (
+	executorField executorFieldType = iota
+	flagsField    flagsFieldType    = iota
+	outputField   outputFieldType   = iota
+)
+
+// IsDryRun returns true if commands are executed in dry-run mode, meaning they should skip their side effects.
+func IsDryRun(ctx context.Context) bool {
+	return tools.IsDryRun(ctx)
+}
+
+func withExecutor(ctx context.Context, e *executor) context.Context {
+	return context.WithValue(ctx, executorField, e)
+}
+
+func getExecutor(ctx context.Context) *executor {
+	return ctx.Value(executorField).(*executor)
+}
+
+func withFlags(ctx context.Context, flags *pflag.FlagSet) context.Context {
+	return context.WithValue(ctx, flagsField, flags)
+}
+
+func getFlags(ctx context.Context) *pflag.FlagSet {
+	flags, _ := ctx.Value(flagsField).(*pflag.FlagSet)
+	return flags
+}
+
+func withOutput(ctx context.Context, out *commandOutput) context.Context {
+	return context.WithValue(ctx, outputField, out)
+}
+
+func getOutput(ctx context.Context) *commandOutput {
+	out, _ := ctx.Value(outputField).(*commandOutput)
+	return out
+}
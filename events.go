@@ -0,0 +1,145 @@
+package build
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap/zapcore"
+)
+
+// jsonEvent is the record stored in the JSON event log.
+type jsonEvent struct {
+	Time             time.Time      `json:"time"`
+	Type             string         `json:"type"`
+	Command          string         `json:"command,omitempty"`
+	Duration         float64        `json:"duration,omitempty"`
+	DurationWithDeps float64        `json:"durationWithDeps,omitempty"`
+	Reason           string         `json:"reason,omitempty"`
+	Error            string         `json:"error,omitempty"`
+	Level            string         `json:"level,omitempty"`
+	Message          string         `json:"message,omitempty"`
+	Fields           map[string]any `json:"fields,omitempty"`
+}
+
+// openJSONEvents opens the file storing the JSON event log, "-" stands for standard output.
+func openJSONEvents(file string) (*jsonEvents, error) {
+	if file == "-" {
+		return newJSONEvents(nopCloser{Writer: os.Stdout}), nil
+	}
+
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return newJSONEvents(f), nil
+}
+
+func newJSONEvents(w io.WriteCloser) *jsonEvents {
+	return &jsonEvents{
+		w:   w,
+		enc: json.NewEncoder(w),
+	}
+}
+
+// jsonEvents writes execution events and log entries as newline-delimited JSON records.
+type jsonEvents struct {
+	mu  sync.Mutex
+	w   io.WriteCloser
+	enc *json.Encoder
+	err error
+}
+
+// CommandStarted is called when command starts.
+func (je *jsonEvents) CommandStarted(event CommandEvent) {
+	je.write(commandJSONEvent("start", event))
+}
+
+// CommandSkipped is called when command is skipped.
+func (je *jsonEvents) CommandSkipped(event CommandEvent) {
+	je.write(commandJSONEvent("skip", event))
+}
+
+// CommandSucceeded is called when command completes successfully.
+func (je *jsonEvents) CommandSucceeded(event CommandEvent) {
+	je.write(commandJSONEvent("finish", event))
+}
+
+// CommandFailed is called when command fails.
+func (je *jsonEvents) CommandFailed(event CommandEvent) {
+	je.write(commandJSONEvent("error", event))
+}
+
+// BuildFinished is called once all the commands complete.
+func (je *jsonEvents) BuildFinished(event BuildEvent) {
+	record := jsonEvent{
+		Time:     event.Time,
+		Type:     "build",
+		Duration: event.Duration.Seconds(),
+	}
+	if event.Err != nil {
+		record.Error = event.Err.Error()
+	}
+	je.write(record)
+}
+
+// LogWritten is called when log entry is written.
+func (je *jsonEvents) LogWritten(entry zapcore.Entry, command string, fields map[string]any) {
+	record := jsonEvent{
+		Time:    entry.Time,
+		Type:    "log",
+		Command: command,
+		Level:   entry.Level.String(),
+		Message: entry.Message,
+	}
+	if len(fields) > 0 {
+		record.Fields = fields
+	}
+	je.write(record)
+}
+
+// Close closes the event log and returns the first error reported while writing it.
+func (je *jsonEvents) Close() error {
+	je.mu.Lock()
+	defer je.mu.Unlock()
+
+	if err := je.w.Close(); err != nil && je.err == nil {
+		je.err = errors.WithStack(err)
+	}
+	return je.err
+}
+
+func (je *jsonEvents) write(record jsonEvent) {
+	je.mu.Lock()
+	defer je.mu.Unlock()
+
+	if err := je.enc.Encode(record); err != nil && je.err == nil {
+		je.err = errors.WithStack(err)
+	}
+}
+
+func commandJSONEvent(eventType string, event CommandEvent) jsonEvent {
+	record := jsonEvent{
+		Time:             event.Time,
+		Type:             eventType,
+		Command:          event.Path,
+		Duration:         event.Duration.Seconds(),
+		DurationWithDeps: event.DurationWithDeps.Seconds(),
+		Reason:           event.Reason,
+	}
+	if event.Err != nil {
+		record.Error = event.Err.Error()
+	}
+	return record
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
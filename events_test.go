@@ -0,0 +1,74 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/outofforest/build/v2/pkg/types"
+	"github.com/outofforest/logger"
+)
+
+func TestJSONEvents(t *testing.T) {
+	buf := &bytes.Buffer{}
+	events := newJSONEvents(nopCloser{Writer: buf})
+
+	core, _ := observer.New(zapcore.InfoLevel)
+	ctx := logger.WithLogger(context.Background(), observeLogs(zap.New(core), events))
+
+	err := execute(ctx, map[string]types.Command{
+		"h": {Fn: cmdH},
+		"b": {Fn: func(ctx context.Context, deps types.DepsFunc) error {
+			logger.Get(ctx).Info("Building", zap.Int("count", 3))
+			logger.Get(ctx).Debug("Hidden")
+			deps(cmdB)
+			return nil
+		}},
+		"c": {Fn: cmdB},
+	}, []string{"h", "b"}, executorConfig{Jobs: 1, Observers: []Observer{events}})
+	require.EqualError(t, err, "error")
+	require.NoError(t, events.Close())
+
+	records := []jsonEvent{}
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var record jsonEvent
+		require.NoError(t, dec.Decode(&record))
+		assert.False(t, record.Time.IsZero())
+		records = append(records, record)
+	}
+
+	summary := []string{}
+	for _, record := range records {
+		summary = append(summary, record.Type+" "+record.Command+" "+record.Message+record.Reason)
+	}
+	assert.Equal(t, []string{
+		"start h ",
+		"start github.com/outofforest/build/v2.cmdHA ",
+		"log github.com/outofforest/build/v2.cmdHA Command finished",
+		"finish github.com/outofforest/build/v2.cmdHA ",
+		"start github.com/outofforest/build/v2.cmdHB ",
+		"log github.com/outofforest/build/v2.cmdHB Command finished",
+		"finish github.com/outofforest/build/v2.cmdHB ",
+		"log h Command finished",
+		"finish h ",
+		"start b ",
+		"log b Building",
+		"start c ",
+		"error c ",
+		"skip b dependency failed",
+		"build  ",
+	}, summary)
+
+	assert.Equal(t, "error", records[12].Error)
+	assert.Equal(t, "error", records[14].Error)
+	assert.Equal(t, "info", records[10].Level)
+	assert.Equal(t, map[string]any{"count": float64(3)}, records[10].Fields)
+}
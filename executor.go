@@ -0,0 +1,656 @@
+package build
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+
+	"github.com/outofforest/build/v2/pkg/tools"
+	"github.com/outofforest/build/v2/pkg/types"
+	"github.com/outofforest/logger"
+)
+
+const maxStack = 100
+
+type executorConfig struct {
+	// Jobs is the maximum number of commands executed concurrently.
+	Jobs int
+
+	// DryRun marks the context passed to commands, so they may skip their side effects.
+	DryRun bool
+
+	// Force causes incremental commands to be executed even if they are up to date.
+	Force bool
+
+	// RemoteCache is the cache used to share outputs of commands between machines.
+	RemoteCache RemoteCache
+
+	// KeepGoing causes commands not depending on the failed ones to be executed.
+	KeepGoing bool
+
+	// Flags are the flags passed to the requested commands.
+	Flags map[string]*pflag.FlagSet
+
+	// Observers are notified about the progress of the execution.
+	Observers []Observer
+
+	// Stdout and Stderr receive the output of commands, standard output and error of the process are used
+	// if they are not set.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// BufferOutput causes the output of the command to be printed only if it fails.
+	BufferOutput bool
+
+	// CaptureOutput causes the output of the command to be passed to observers.
+	CaptureOutput bool
+}
+
+func execute(ctx context.Context, commands map[string]types.Command, paths []string, config executorConfig) error {
+	return newExecutor(commands, config).Execute(ctx, paths)
+}
+
+// CycleError is returned when dependency cycle is detected.
+type CycleError struct {
+	// Path lists the commands forming the cycle, the first and the last items are the same.
+	Path []string
+}
+
+// Error returns string representation of error.
+func (e CycleError) Error() string {
+	return "build: dependency cycle detected: " + strings.Join(e.Path, " -> ")
+}
+
+// StackOverflowError is returned when maximum length of stack is reached.
+type StackOverflowError struct {
+	// Stack lists the commands on the stack, starting from the one requested by the user.
+	Stack []string
+}
+
+// Error returns string representation of error.
+func (e StackOverflowError) Error() string {
+	return "build: maximum length of stack reached: " + strings.Join(e.Stack, " -> ")
+}
+
+// CommandError is the error returned by a command, reported when commands are executed in keep-going mode.
+type CommandError struct {
+	Path string
+	Err  error
+}
+
+// Error returns string representation of error.
+func (e CommandError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+// Unwrap returns next error.
+func (e CommandError) Unwrap() error {
+	return e.Err
+}
+
+// TimeoutError is returned when command does not complete within its timeout.
+type TimeoutError struct {
+	Path    string
+	Timeout time.Duration
+	Err     error
+}
+
+// Error returns string representation of error.
+func (e TimeoutError) Error() string {
+	return fmt.Sprintf("build: command %s timed out after %s: %s", e.Path, e.Timeout, e.Err)
+}
+
+// Unwrap returns next error.
+func (e TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// invocation represents single execution of a command.
+type invocation struct {
+	cmd     types.CommandFunc
+	name    string
+	timeout time.Duration
+	parent  *invocation
+	depth   int
+	done    chan struct{}
+	err     error
+	// skipReason is set if command was not executed because it was up to date.
+	skipReason string
+	// depFailed is set if command failed because one of its dependencies failed.
+	depFailed bool
+	// output collects the output of the command.
+	output *commandOutput
+
+	requested time.Time
+	started   time.Time
+	finished  time.Time
+	// waited is the time spent on waiting for dependencies.
+	waited time.Duration
+	// segments are the periods of time when the command was occupying a lane.
+	segments []segment
+
+	// deps contains dependencies requested by the invocation, guarded by executor.mu.
+	deps []*invocation
+
+	// waitingFor contains invocations this one is waiting for, guarded by executor.mu.
+	waitingFor []*invocation
+}
+
+// segment is the period of time when command was occupying a lane.
+type segment struct {
+	Lane  int
+	Start time.Time
+	End   time.Time
+}
+
+// planStep is the step of the execution plan.
+type planStep struct {
+	Path        string
+	Description string
+}
+
+// depsError is used to unwind the command when its dependency failed.
+type depsError struct {
+	err error
+}
+
+func (e depsError) Error() string {
+	return e.err.Error()
+}
+
+func newExecutor(commands map[string]types.Command, config executorConfig) *executor {
+	if config.Jobs < 1 {
+		config.Jobs = 1
+	}
+	if config.Stdout == nil {
+		config.Stdout = os.Stdout
+	}
+	if config.Stderr == nil {
+		config.Stderr = os.Stderr
+	}
+
+	names := map[reflect.Value]string{}
+	for _, path := range paths(commands) {
+		cmdValue := reflect.ValueOf(commands[path].Fn)
+		if _, exists := names[cmdValue]; !exists {
+			names[cmdValue] = path
+		}
+	}
+
+	lanes := make(chan int, config.Jobs)
+	for lane := range config.Jobs {
+		lanes <- lane
+	}
+
+	return &executor{
+		commands:    commands,
+		config:      config,
+		names:       names,
+		lanes:       lanes,
+		root:        &invocation{},
+		invocations: map[reflect.Value]*invocation{},
+		results:     map[string]any{},
+	}
+}
+
+type executor struct {
+	commands map[string]types.Command
+	config   executorConfig
+	names    map[reflect.Value]string
+	lanes    chan int
+	root     *invocation
+	started  time.Time
+
+	cacheOnce sync.Once
+	cache     *localCache
+
+	// outputMu is locked while writing lines of the output, so lines of different commands are not mixed.
+	outputMu sync.Mutex
+
+	mu          sync.Mutex
+	invocations map[reflect.Value]*invocation
+	cancel      context.CancelFunc
+	err         error
+	failures    []error
+	results     map[string]any
+	deferred    []func(ctx context.Context) error
+}
+
+// Execute executes commands one by one, each of them running its dependencies concurrently.
+func (e *executor) Execute(ctx context.Context, paths []string) (retErr error) {
+	e.started = time.Now()
+	defer func() {
+		e.notify(func(o Observer) {
+			o.BuildFinished(BuildEvent{Time: time.Now(), Duration: time.Since(e.started), Err: retErr})
+		})
+	}()
+
+	pathsTrimmed := make([]string, 0, len(paths))
+	for _, p := range paths {
+		if p[len(p)-1] == '/' {
+			p = p[:len(p)-1]
+		}
+		pathsTrimmed = append(pathsTrimmed, p)
+	}
+	pathsTrimmed, err := expandPaths(e.commands, pathsTrimmed)
+	if err != nil {
+		return err
+	}
+
+	log := logger.Get(ctx)
+	initDeps := make([]types.CommandFunc, 0, len(pathsTrimmed))
+	for _, p := range pathsTrimmed {
+		path, cmd, exists := lookupCommand(e.commands, p)
+		if !exists {
+			return errors.Errorf("build: command %s does not exist", p)
+		}
+		if path != p {
+			log.Warn("Command has been renamed, use the new path", zap.String("alias", p),
+				zap.String("command", path))
+		}
+		initDeps = append(initDeps, cmd.Fn)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	e.cancel = cancel
+
+	ctx = withExecutor(ctx, e)
+	if e.config.DryRun {
+		ctx = tools.WithDryRun(ctx)
+	}
+
+	for _, cmd := range initDeps {
+		err := e.runDeps(ctx, e.root, []types.CommandFunc{cmd})
+		if err == nil {
+			continue
+		}
+		if !errors.As(err, &depsError{}) {
+			e.fail(e.root, err)
+		}
+		if !e.config.KeepGoing {
+			break
+		}
+	}
+
+	err = e.result()
+	if cleanupErr := e.runDeferred(ctx); cleanupErr != nil {
+		return goerrors.Join(err, cleanupErr)
+	}
+	return err
+}
+
+func (e *executor) result() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.config.KeepGoing {
+		return goerrors.Join(e.failures...)
+	}
+	return e.err
+}
+
+func (e *executor) runDeps(ctx context.Context, parent *invocation, deps []types.CommandFunc) error {
+	e.mu.Lock()
+	invs := make([]*invocation, 0, len(deps))
+	var err error
+	for _, cmd := range deps {
+		var inv *invocation
+		inv, err = e.schedule(ctx, parent, cmd)
+		if err != nil {
+			break
+		}
+		invs = append(invs, inv)
+		if !lo.Contains(parent.deps, inv) {
+			parent.deps = append(parent.deps, inv)
+		}
+	}
+	parent.waitingFor = invs
+	e.mu.Unlock()
+
+	if parent != e.root {
+		// Lane is released while waiting for dependencies, otherwise they could never be executed
+		// if all the lanes are taken by their dependents.
+		waitStarted := time.Now()
+		e.release(parent)
+		defer func() {
+			e.acquire(parent)
+			parent.waited += time.Since(waitStarted)
+		}()
+	}
+
+	for _, inv := range invs {
+		<-inv.done
+	}
+
+	e.mu.Lock()
+	parent.waitingFor = nil
+	e.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	for _, inv := range invs {
+		if inv.err != nil {
+			return depsError{err: inv.err}
+		}
+	}
+	return nil
+}
+
+// schedule starts the invocation of the command or returns the one which has been started before.
+// It must be called with e.mu held.
+func (e *executor) schedule(ctx context.Context, parent *invocation, cmd types.CommandFunc) (*invocation, error) {
+	cmd, options := defaultWrapperRegistry.Unwrap(cmd)
+	cmdValue := reflect.ValueOf(cmd)
+	if inv, exists := e.invocations[cmdValue]; exists {
+		if chain := inv.waitChain(parent); chain != nil {
+			path := make([]string, 0, len(chain)+1)
+			for _, inv := range chain {
+				path = append(path, inv.name)
+			}
+			return nil, CycleError{Path: append(path, inv.name)}
+		}
+		return inv, nil
+	}
+
+	name := e.name(cmdValue)
+	if parent.depth >= maxStack {
+		stack := make([]string, parent.depth+1)
+		stack[parent.depth] = name
+		for inv := parent; inv.parent != nil; inv = inv.parent {
+			stack[inv.depth-1] = inv.name
+		}
+		return nil, StackOverflowError{Stack: stack}
+	}
+
+	timeout := options.Timeout
+	if timeout == 0 {
+		timeout = e.commands[name].Timeout
+	}
+
+	inv := &invocation{
+		cmd:     cmd,
+		name:    name,
+		timeout: timeout,
+		parent:  parent,
+		depth:   parent.depth + 1,
+		done:    make(chan struct{}),
+
+		requested: time.Now(),
+	}
+	e.invocations[cmdValue] = inv
+	go e.run(ctx, inv)
+
+	return inv, nil
+}
+
+func (e *executor) run(ctx context.Context, inv *invocation) {
+	defer close(inv.done)
+
+	e.acquire(inv)
+	defer e.release(inv)
+
+	inv.started = time.Now()
+	e.notify(func(o Observer) {
+		o.CommandStarted(CommandEvent{Path: inv.name, Time: inv.started})
+	})
+	if cmd, exists := e.commands[inv.name]; exists && cmd.Deprecated != "" {
+		logger.Get(ctx).Warn("Command is deprecated", zap.String("command", inv.name),
+			zap.String("message", cmd.Deprecated))
+	}
+
+	inv.output = newCommandOutput(inv.name, &e.outputMu, e.config.Stdout, e.config.Stderr,
+		e.config.BufferOutput, e.config.CaptureOutput)
+	err := e.call(ctx, inv)
+	inv.finished = time.Now()
+
+	inv.output.Flush()
+	if err != nil && !inv.depFailed {
+		inv.output.Dump(e.config.Stderr)
+	}
+
+	event := CommandEvent{
+		Path:             inv.name,
+		Time:             inv.finished,
+		Duration:         inv.exclusiveDuration(),
+		DurationWithDeps: inv.inclusiveDuration(),
+		Output:           inv.output.Captured(),
+		Err:              err,
+	}
+	log := logger.Get(ctx).With(zap.String("command", inv.name))
+	switch {
+	case err == nil && inv.skipReason != "":
+		event.Reason = inv.skipReason
+		e.notify(func(o Observer) { o.CommandSkipped(event) })
+	case err == nil:
+		log.Info("Command finished", zap.Duration("duration", inv.exclusiveDuration()),
+			zap.Duration("durationWithDeps", inv.inclusiveDuration()))
+		e.notify(func(o Observer) { o.CommandSucceeded(event) })
+	case inv.depFailed:
+		inv.err = err
+		log.Debug("Command skipped because its dependency failed")
+		event.Reason = skipReasonDepFailed
+		e.notify(func(o Observer) { o.CommandSkipped(event) })
+	default:
+		inv.err = err
+		e.fail(inv, err)
+		log.Debug("Command failed", zap.Duration("duration", inv.exclusiveDuration()),
+			zap.Duration("durationWithDeps", inv.inclusiveDuration()))
+		e.notify(func(o Observer) { o.CommandFailed(event) })
+	}
+}
+
+func (e *executor) call(ctx context.Context, inv *invocation) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if inv.timeout == 0 {
+		return e.callCommand(ctx, ctx, inv)
+	}
+
+	cmdCtx, cancel := context.WithTimeout(ctx, inv.timeout)
+	defer cancel()
+
+	err := e.callCommand(cmdCtx, ctx, inv)
+	if err != nil && !inv.depFailed && errors.Is(cmdCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		return TimeoutError{Path: inv.name, Timeout: inv.timeout, Err: err}
+	}
+	return err
+}
+
+// callCommand executes the command. Its dependencies are executed using depsCtx, so they are not affected
+// by the timeout of the command.
+func (e *executor) callCommand(ctx, depsCtx context.Context, inv *invocation) (retErr error) {
+	defer func() {
+		if r := recover(); r != nil {
+			var dErr depsError
+			err, ok := r.(error)
+			switch {
+			case ok && errors.As(err, &dErr):
+				inv.depFailed = true
+				retErr = dErr.err
+			case ok:
+				retErr = err
+			default:
+				retErr = errors.Errorf("command panicked: %v", r)
+			}
+		}
+	}()
+
+	deps := func(deps ...types.CommandFunc) {
+		if err := e.runDeps(depsCtx, inv, deps); err != nil {
+			panic(err)
+		}
+		// Command might time out while waiting for its dependencies.
+		if err := ctx.Err(); err != nil {
+			panic(errors.WithStack(err))
+		}
+	}
+
+	ctx = logger.With(withOutput(ctx, inv.output), zap.String("command", inv.name))
+	cmd, exists := e.commands[inv.name]
+	if exists {
+		flags, err := e.flagSet(inv.name, cmd)
+		if err != nil {
+			return err
+		}
+		ctx = withFlags(ctx, flags)
+		if len(cmd.Deps) > 0 {
+			deps(cmd.Deps...)
+		}
+	}
+	if exists && isIncremental(cmd) {
+		return e.callIncremental(ctx, inv, cmd, deps)
+	}
+	return inv.cmd(ctx, deps)
+}
+
+// flagSet returns flags passed to the command. Commands executed as dependencies receive default values.
+func (e *executor) flagSet(path string, cmd types.Command) (*pflag.FlagSet, error) {
+	if flags, exists := e.config.Flags[path]; exists {
+		return flags, nil
+	}
+	return newFlagSet(path, cmd)
+}
+
+// Plan returns the commands executed so far, each one preceded by its dependencies.
+func (e *executor) Plan() []planStep {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	invs := e.invocationsInOrder()
+	plan := make([]planStep, 0, len(invs))
+	for _, inv := range invs {
+		plan = append(plan, planStep{
+			Path:        inv.name,
+			Description: e.commands[inv.name].Description,
+		})
+	}
+	return plan
+}
+
+// invocationsInOrder returns invocations, each one preceded by its dependencies.
+// It must be called with e.mu held.
+func (e *executor) invocationsInOrder() []*invocation {
+	invs := []*invocation{}
+	visited := map[*invocation]bool{}
+	var walk func(inv *invocation)
+	walk = func(inv *invocation) {
+		for _, dep := range inv.deps {
+			if visited[dep] {
+				continue
+			}
+			visited[dep] = true
+			walk(dep)
+			invs = append(invs, dep)
+		}
+	}
+	walk(e.root)
+
+	return invs
+}
+
+func (e *executor) getCache(ctx context.Context) *localCache {
+	e.cacheOnce.Do(func() {
+		e.cache = newLocalCache(cacheDir(ctx), defaultCacheMaxSize)
+	})
+	return e.cache
+}
+
+// acquire waits for a free lane and assigns it to the invocation.
+func (e *executor) acquire(inv *invocation) {
+	lane := <-e.lanes
+	inv.segments = append(inv.segments, segment{Lane: lane, Start: time.Now()})
+}
+
+// release frees the lane occupied by the invocation.
+func (e *executor) release(inv *invocation) {
+	s := &inv.segments[len(inv.segments)-1]
+	s.End = time.Now()
+	e.lanes <- s.Lane
+}
+
+// name returns the path under which the command is registered or the name of the function if it's not.
+// Name of the function bound to the key includes the key.
+func (e *executor) name(cmdValue reflect.Value) string {
+	if name, exists := e.names[cmdValue]; exists {
+		return name
+	}
+	if name, exists := defaultWrapperRegistry.Name(cmdValue); exists {
+		return name
+	}
+	return funcName(cmdValue)
+}
+
+func (e *executor) fail(inv *invocation, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.config.KeepGoing {
+		e.failures = append(e.failures, CommandError{Path: inv.name, Err: err})
+		return
+	}
+	if e.err == nil {
+		e.err = err
+		e.cancel()
+	}
+}
+
+// exclusiveDuration returns the time spent on executing the command, excluding its dependencies.
+func (inv *invocation) exclusiveDuration() time.Duration {
+	return inv.inclusiveDuration() - inv.waited
+}
+
+// inclusiveDuration returns the time spent on executing the command, including its dependencies.
+func (inv *invocation) inclusiveDuration() time.Duration {
+	return inv.finished.Sub(inv.started)
+}
+
+func (inv *invocation) isDone() bool {
+	select {
+	case <-inv.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitChain returns the chain of invocations leading from this one to the other one, if this one waits for it,
+// directly or indirectly. Otherwise nil is returned.
+// It must be called with executor.mu held.
+func (inv *invocation) waitChain(inv2 *invocation) []*invocation {
+	if inv == inv2 {
+		return []*invocation{inv}
+	}
+
+	visited := map[*invocation]bool{inv: true}
+	var find func(inv *invocation) []*invocation
+	find = func(inv *invocation) []*invocation {
+		for _, dep := range inv.waitingFor {
+			if dep == inv2 {
+				return []*invocation{inv, dep}
+			}
+			if visited[dep] {
+				continue
+			}
+			visited[dep] = true
+			if chain := find(dep); chain != nil {
+				return append([]*invocation{inv}, chain...)
+			}
+		}
+		return nil
+	}
+	return find(inv)
+}
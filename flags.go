@@ -0,0 +1,148 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/spf13/pflag"
+
+	"github.com/outofforest/build/v2/pkg/types"
+)
+
+// newFlagSet returns the flag set defining flags of the command.
+func newFlagSet(path string, cmd types.Command) (*pflag.FlagSet, error) {
+	flags := pflag.NewFlagSet(path, pflag.ContinueOnError)
+	for _, flag := range cmd.Flags {
+		if flag.Name == "" {
+			return nil, errors.Errorf("flag of command %s has no name", path)
+		}
+		if flags.Lookup(flag.Name) != nil {
+			return nil, errors.Errorf("flag %s of command %s has already been defined", flag.Name, path)
+		}
+
+		switch value := flag.Default.(type) {
+		case string:
+			flags.String(flag.Name, value, flag.Help)
+		case bool:
+			flags.Bool(flag.Name, value, flag.Help)
+		case int:
+			flags.Int(flag.Name, value, flag.Help)
+		case float64:
+			flags.Float64(flag.Name, value, flag.Help)
+		case time.Duration:
+			flags.Duration(flag.Name, value, flag.Help)
+		case []string:
+			flags.StringSlice(flag.Name, value, flag.Help)
+		default:
+			return nil, errors.Errorf("flag %s of command %s has unsupported type %T", flag.Name, path, flag.Default)
+		}
+	}
+	return flags, nil
+}
+
+// parseArgs parses the command line. Each command path may be followed by the flags of that command,
+// global flags are accepted everywhere. Flag sets of the requested commands are returned.
+func parseArgs(
+	globalFlags *pflag.FlagSet,
+	commands map[string]types.Command,
+	args []string,
+) ([]string, map[string]*pflag.FlagSet, error) {
+	paths := []string{}
+	commandFlags := map[string]*pflag.FlagSet{}
+
+	flags := globalFlags
+	for {
+		flags.SetInterspersed(false)
+		if err := flags.Parse(args); err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return paths, commandFlags, nil
+		}
+
+		arg := strings.TrimSuffix(args[0], "/")
+		args = args[1:]
+		paths = append(paths, arg)
+
+		// Commands selected by the pattern may accept different flags, so only global ones are accepted after it.
+		if isPattern(arg) {
+			flags = globalFlags
+			continue
+		}
+
+		path, cmd, exists := lookupCommand(commands, arg)
+		if !exists {
+			return nil, nil, errors.Errorf("build: command %s does not exist", arg)
+		}
+
+		var err error
+		flags, err = newFlagSet(path, cmd)
+		if err != nil {
+			return nil, nil, err
+		}
+		flags.AddFlagSet(globalFlags)
+		commandFlags[path] = flags
+	}
+}
+
+// flagsFingerprint returns the string representing values of the flags declared by the command, so changing them
+// invalidates results of incremental commands. Global flags are not included, because they don't affect outputs.
+func flagsFingerprint(ctx context.Context, cmd types.Command) string {
+	flags := getFlags(ctx)
+	if flags == nil {
+		return ""
+	}
+
+	values := []string{}
+	for _, f := range cmd.Flags {
+		if flag := flags.Lookup(f.Name); flag != nil {
+			values = append(values, fmt.Sprintf("%s=%s", flag.Name, flag.Value.String()))
+		}
+	}
+	sort.Strings(values)
+	return strings.Join(values, "\n")
+}
+
+// StringFlag returns the value of the string flag passed to the command.
+func StringFlag(ctx context.Context, name string) string {
+	return lo.Must(mustGetFlags(ctx, name).GetString(name))
+}
+
+// BoolFlag returns the value of the bool flag passed to the command.
+func BoolFlag(ctx context.Context, name string) bool {
+	return lo.Must(mustGetFlags(ctx, name).GetBool(name))
+}
+
+// IntFlag returns the value of the int flag passed to the command.
+func IntFlag(ctx context.Context, name string) int {
+	return lo.Must(mustGetFlags(ctx, name).GetInt(name))
+}
+
+// Float64Flag returns the value of the float64 flag passed to the command.
+func Float64Flag(ctx context.Context, name string) float64 {
+	return lo.Must(mustGetFlags(ctx, name).GetFloat64(name))
+}
+
+// DurationFlag returns the value of the duration flag passed to the command.
+func DurationFlag(ctx context.Context, name string) time.Duration {
+	return lo.Must(mustGetFlags(ctx, name).GetDuration(name))
+}
+
+// StringSliceFlag returns the value of the string slice flag passed to the command.
+func StringSliceFlag(ctx context.Context, name string) []string {
+	return lo.Must(mustGetFlags(ctx, name).GetStringSlice(name))
+}
+
+func mustGetFlags(ctx context.Context, name string) *pflag.FlagSet {
+	flags := getFlags(ctx)
+	if flags == nil || flags.Lookup(name) == nil {
+		panic(errors.Errorf("flag %s is not defined for the command", name))
+	}
+	return flags
+}
@@ -0,0 +1,142 @@
+package build
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/outofforest/build/v2/pkg/types"
+)
+
+var flagCommands = map[string]types.Command{
+	"deploy/db": {
+		Fn: func(_ context.Context, _ types.DepsFunc) error { return nil },
+		Flags: []types.Flag{
+			{Name: "env", Default: "dev"},
+			{Name: "replicas", Default: 1},
+			{Name: "wait", Default: time.Second},
+			{Name: "dry", Default: false},
+			{Name: "tags", Default: []string{}},
+		},
+	},
+	"test": {
+		Fn: func(_ context.Context, _ types.DepsFunc) error { return nil },
+	},
+}
+
+func globalFlags() *pflag.FlagSet {
+	flags := pflag.NewFlagSet("build", pflag.ContinueOnError)
+	flags.IntP("jobs", "j", 1, "")
+	return flags
+}
+
+func TestParseArgs(t *testing.T) {
+	flags := globalFlags()
+	paths, commandFlags, err := parseArgs(flags, flagCommands, []string{
+		"-j", "4", "deploy/db/", "--env=staging", "--replicas", "3", "--tags=a,b", "test", "-j", "8",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"deploy/db", "test"}, paths)
+	assert.Equal(t, 8, lo.Must(flags.GetInt("jobs")))
+
+	deployFlags := commandFlags["deploy/db"]
+	assert.Equal(t, "staging", lo.Must(deployFlags.GetString("env")))
+	assert.Equal(t, 3, lo.Must(deployFlags.GetInt("replicas")))
+	assert.Equal(t, time.Second, lo.Must(deployFlags.GetDuration("wait")))
+	assert.Equal(t, []string{"a", "b"}, lo.Must(deployFlags.GetStringSlice("tags")))
+	assert.Nil(t, commandFlags["test"].Lookup("env"))
+}
+
+func TestFlagsFingerprint(t *testing.T) {
+	cmd := flagCommands["deploy/db"]
+	fingerprint := func(args ...string) string {
+		_, commandFlags, err := parseArgs(globalFlags(), flagCommands, args)
+		require.NoError(t, err)
+		return flagsFingerprint(withFlags(tCtx, commandFlags["deploy/db"]), cmd)
+	}
+	defaults, err := newFlagSet("deploy/db", cmd)
+	require.NoError(t, err)
+
+	// Global flags don't affect the fingerprint, so it is the same as the one of the dependency.
+	assert.Equal(t, fingerprint("deploy/db", "-j", "2"), fingerprint("deploy/db", "-j", "8"))
+	assert.Equal(t, flagsFingerprint(withFlags(tCtx, defaults), cmd), fingerprint("deploy/db", "-j", "2"))
+	assert.NotEqual(t, fingerprint("deploy/db"), fingerprint("deploy/db", "--env=staging"))
+}
+
+func TestParseArgsFailsOnFlagOfAnotherCommand(t *testing.T) {
+	_, _, err := parseArgs(globalFlags(), flagCommands, []string{"test", "--env=staging"})
+	require.Error(t, err)
+}
+
+func TestParseArgsFailsOnMissingCommand(t *testing.T) {
+	_, _, err := parseArgs(globalFlags(), flagCommands, []string{"missing", "--env=staging"})
+	require.EqualError(t, err, "build: command missing does not exist")
+}
+
+func TestFlagsArePassedToCommand(t *testing.T) {
+	var env, depEnv string
+	var replicas int
+	dep := func(ctx context.Context, _ types.DepsFunc) error {
+		depEnv = StringFlag(ctx, "env")
+		return nil
+	}
+	commands := map[string]types.Command{
+		"deploy/db": {
+			Fn: func(ctx context.Context, deps types.DepsFunc) error {
+				deps(dep)
+				env = StringFlag(ctx, "env")
+				replicas = IntFlag(ctx, "replicas")
+				return nil
+			},
+			Flags: []types.Flag{
+				{Name: "env", Default: "dev"},
+				{Name: "replicas", Default: 1},
+			},
+		},
+		"dep": {
+			Fn:    dep,
+			Flags: []types.Flag{{Name: "env", Default: "dev"}},
+		},
+	}
+
+	paths, commandFlags, err := parseArgs(globalFlags(), commands, []string{"deploy/db", "--env=staging"})
+	require.NoError(t, err)
+	require.NoError(t, execute(tCtx, commands, paths, executorConfig{Jobs: 4, Flags: commandFlags}))
+
+	assert.Equal(t, "staging", env)
+	assert.Equal(t, 1, replicas)
+	assert.Equal(t, "dev", depEnv)
+}
+
+func TestUndefinedFlagPanics(t *testing.T) {
+	err := execute(tCtx, map[string]types.Command{
+		"test": {Fn: func(ctx context.Context, _ types.DepsFunc) error {
+			StringFlag(ctx, "env")
+			return nil
+		}},
+	}, []string{"test"}, executorConfig{Jobs: 4})
+	require.EqualError(t, err, "flag env is not defined for the command")
+}
+
+func TestRegisteringInvalidFlagFails(t *testing.T) {
+	registry := newCommandRegistry()
+	err := registry.RegisterCommands([]map[string]types.Command{
+		{
+			"test": {Flags: []types.Flag{{Name: "count", Default: uint(1)}}},
+		},
+	})
+	require.EqualError(t, err, "flag count of command test has unsupported type uint")
+
+	err = registry.RegisterCommands([]map[string]types.Command{
+		{
+			"test": {Flags: []types.Flag{{Name: "env", Default: ""}, {Name: "env", Default: ""}}},
+		},
+	})
+	require.EqualError(t, err, "flag env of command test has already been defined")
+}
@@ -10,6 +10,7 @@ require (
 	github.com/outofforest/tools v1.4.3
 	github.com/pkg/errors v0.9.1
 	github.com/samber/lo v1.52.0
+	github.com/spf13/pflag v1.0.10
 	github.com/stretchr/testify v1.11.1
 	go.uber.org/zap v1.27.1
 	golang.org/x/mod v0.32.0
@@ -20,7 +21,6 @@ require (
 	github.com/outofforest/ioc/v2 v2.5.2 // indirect
 	github.com/outofforest/parallel v0.2.3 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/spf13/pflag v1.0.10 // indirect
 	github.com/ulikunitz/xz v0.5.15 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
 	golang.org/x/text v0.33.0 // indirect
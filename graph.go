@@ -0,0 +1,151 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/outofforest/build/v2/pkg/types"
+	"github.com/outofforest/logger"
+)
+
+// graph is the dependency graph of executed commands.
+type graph struct {
+	Nodes []graphNode `json:"nodes"`
+	Edges []graphEdge `json:"edges"`
+}
+
+type graphNode struct {
+	Path        string `json:"path"`
+	Description string `json:"description,omitempty"`
+}
+
+type graphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Graph returns the dependency graph of commands completed so far.
+func (e *executor) Graph() graph {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	g := graph{
+		Nodes: []graphNode{},
+		Edges: []graphEdge{},
+	}
+	for _, inv := range e.invocationsInOrder() {
+		if !inv.isDone() {
+			continue
+		}
+		g.Nodes = append(g.Nodes, graphNode{
+			Path:        inv.name,
+			Description: e.commands[inv.name].Description,
+		})
+		for _, dep := range inv.deps {
+			g.Edges = append(g.Edges, graphEdge{From: inv.name, To: dep.name})
+		}
+	}
+	return g
+}
+
+// printGraph prints the dependency graph of commands selected by the `target` flag. Graph is discovered by executing
+// them in dry-run mode, so targets must be specified explicitly.
+func printGraph(ctx context.Context, _ types.DepsFunc) error {
+	if IsDryRun(ctx) {
+		return nil
+	}
+
+	format := StringFlag(ctx, "format")
+	var write func(w io.Writer, g graph) error
+	switch format {
+	case "dot":
+		write = writeDOT
+	case "mermaid":
+		write = writeMermaid
+	case "json":
+		write = writeJSON
+	default:
+		return errors.Errorf("build: graph format %s is not supported", format)
+	}
+
+	targets := StringSliceFlag(ctx, "target")
+	if len(targets) == 0 {
+		return errors.New("build: at least one target must be specified")
+	}
+
+	g, err := discoverGraph(ctx, getExecutor(ctx).commands, targets)
+	if err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	if err := write(buf, g); err != nil {
+		return err
+	}
+	_, err = rawStdout(ctx).Write(buf.Bytes())
+	return errors.WithStack(err)
+}
+
+// discoverGraph executes commands in dry-run mode and returns the graph of their dependencies.
+func discoverGraph(ctx context.Context, commands map[string]types.Command, paths []string) (graph, error) {
+	e := newExecutor(commands, executorConfig{
+		Jobs:   1,
+		DryRun: true,
+		Stdout: io.Discard,
+		Stderr: io.Discard,
+	})
+	if err := e.Execute(logger.WithLogger(ctx, zap.NewNop()), paths); err != nil {
+		return graph{}, err
+	}
+	return e.Graph(), nil
+}
+
+func writeJSON(w io.Writer, g graph) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return errors.WithStack(encoder.Encode(g))
+}
+
+func writeDOT(w io.Writer, g graph) error {
+	if _, err := fmt.Fprintln(w, "digraph build {"); err != nil {
+		return errors.WithStack(err)
+	}
+	for _, node := range g.Nodes {
+		if _, err := fmt.Fprintf(w, "  %s [tooltip=%s];\n", strconv.Quote(node.Path),
+			strconv.Quote(node.Description)); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	for _, edge := range g.Edges {
+		if _, err := fmt.Fprintf(w, "  %s -> %s;\n", strconv.Quote(edge.From), strconv.Quote(edge.To)); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return errors.WithStack(err)
+}
+
+func writeMermaid(w io.Writer, g graph) error {
+	if _, err := fmt.Fprintln(w, "graph TD"); err != nil {
+		return errors.WithStack(err)
+	}
+	ids := map[string]string{}
+	for i, node := range g.Nodes {
+		ids[node.Path] = "n" + strconv.Itoa(i)
+		if _, err := fmt.Fprintf(w, "  %s[%s]\n", ids[node.Path], strconv.Quote(node.Path)); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	for _, edge := range g.Edges {
+		if _, err := fmt.Fprintf(w, "  %s --> %s\n", ids[edge.From], ids[edge.To]); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/outofforest/build/v2/pkg/types"
+)
+
+func TestGraph(t *testing.T) {
+	var g graph
+	e := newExecutor(map[string]types.Command{
+		"a":    {Fn: cmdA, Description: "Command A"},
+		"a/aa": {Fn: cmdAA},
+		"a/ab": {Fn: cmdAB},
+		"graph": {Fn: func(ctx context.Context, _ types.DepsFunc) error {
+			g = getExecutor(ctx).Graph()
+			return nil
+		}},
+	}, executorConfig{Jobs: 4})
+	r = &recorder{}
+	require.NoError(t, e.Execute(tCtx, []string{"a", "graph"}))
+
+	assert.Equal(t, graph{
+		Nodes: []graphNode{
+			{Path: "github.com/outofforest/build/v2.cmdAC"},
+			{Path: "a/aa"},
+			{Path: "a/ab"},
+			{Path: "a", Description: "Command A"},
+		},
+		Edges: []graphEdge{
+			{From: "a/aa", To: "github.com/outofforest/build/v2.cmdAC"},
+			{From: "a/ab", To: "github.com/outofforest/build/v2.cmdAC"},
+			{From: "a", To: "a/aa"},
+			{From: "a", To: "a/ab"},
+		},
+	}, g)
+}
+
+func TestDiscoverGraph(t *testing.T) {
+	var dryRun bool
+	g, err := discoverGraph(tCtx, map[string]types.Command{
+		"a":    {Fn: cmdA, Description: "Command A"},
+		"a/aa": {Fn: cmdAA},
+		"b": {Fn: func(ctx context.Context, deps types.DepsFunc) error {
+			deps(cmdAA)
+			dryRun = IsDryRun(ctx)
+			return nil
+		}},
+		"graph": {Fn: printGraph},
+	}, []string{"b", "graph"})
+	require.NoError(t, err)
+
+	assert.True(t, dryRun)
+	assert.Equal(t, graph{
+		Nodes: []graphNode{
+			{Path: "github.com/outofforest/build/v2.cmdAC"},
+			{Path: "a/aa"},
+			{Path: "b"},
+			{Path: "graph"},
+		},
+		Edges: []graphEdge{
+			{From: "a/aa", To: "github.com/outofforest/build/v2.cmdAC"},
+			{From: "b", To: "a/aa"},
+		},
+	}, g)
+}
+
+func TestGraphCommand(t *testing.T) {
+	commands := map[string]types.Command{
+		"a/aa":  {Fn: cmdAA},
+		"graph": Commands["graph"],
+	}
+	_, commandFlags, err := parseArgs(globalFlags(), commands, []string{"graph", "--format=mermaid", "--target=a/aa"})
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, execute(tCtx, commands, []string{"graph"}, executorConfig{
+		Jobs:   1,
+		Flags:  commandFlags,
+		Stdout: buf,
+	}))
+	assert.Equal(t, `graph TD
+  n0["github.com/outofforest/build/v2.cmdAC"]
+  n1["a/aa"]
+  n1 --> n0
+`, buf.String())
+
+	_, commandFlags, err = parseArgs(globalFlags(), commands, []string{"graph"})
+	require.NoError(t, err)
+	err = execute(tCtx, commands, []string{"graph"}, executorConfig{Jobs: 1, Flags: commandFlags})
+	require.ErrorContains(t, err, "build: at least one target must be specified")
+}
+
+func TestGraphFormats(t *testing.T) {
+	g := graph{
+		Nodes: []graphNode{
+			{Path: "a/aa"},
+			{Path: "a", Description: "Command A"},
+		},
+		Edges: []graphEdge{
+			{From: "a", To: "a/aa"},
+		},
+	}
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, writeDOT(buf, g))
+	assert.Equal(t, `digraph build {
+  "a/aa" [tooltip=""];
+  "a" [tooltip="Command A"];
+  "a" -> "a/aa";
+}
+`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, writeJSON(buf, g))
+	assert.JSONEq(t, `{
+  "nodes": [{"path": "a/aa"}, {"path": "a", "description": "Command A"}],
+  "edges": [{"from": "a", "to": "a/aa"}]
+}`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, writeMermaid(buf, g))
+	assert.Equal(t, `graph TD
+  n0["a/aa"]
+  n1["a"]
+  n1 --> n0
+`, buf.String())
+}
@@ -0,0 +1,175 @@
+package build
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/build/v2/pkg/types"
+)
+
+const helpCommand = "help"
+
+// help prints the help of the command, or lists commands if path is not provided or there are commands under it.
+func help(w io.Writer, commands map[string]types.Command, args []string) error {
+	switch len(args) {
+	case 0:
+		listCommands(w, commands, "")
+		return nil
+	case 1:
+	default:
+		return errors.New("build: usage: help [command]")
+	}
+
+	path := strings.TrimSuffix(args[0], "/")
+	if path, cmd, exists := lookupCommand(commands, path); exists {
+		return printHelp(w, path, cmd, commands)
+	}
+	if hasSubtree(commands, path+"/") {
+		listCommands(w, commands, path+"/")
+		return nil
+	}
+	return errors.Errorf("build: command %s does not exist", path)
+}
+
+// subtreePrefix returns the prefix if the only argument is the path followed by "/" and there are commands under it.
+func subtreePrefix(commands map[string]types.Command, args []string) (string, bool) {
+	if len(args) != 1 || !strings.HasSuffix(args[0], "/") || !hasSubtree(commands, args[0]) {
+		return "", false
+	}
+	return args[0], true
+}
+
+func hasSubtree(commands map[string]types.Command, prefix string) bool {
+	for _, path := range visiblePaths(commands) {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// builtinCommands are the commands handled before the registered ones, listed together with them.
+var builtinCommands = map[string]string{
+	completionCommand: "Prints the shell completion script: bash, zsh or fish",
+	helpCommand:       "Prints help of the command",
+}
+
+// listCommands prints paths and descriptions of commands starting with the prefix.
+// Built-in commands are listed if prefix is empty.
+func listCommands(w io.Writer, commands map[string]types.Command, prefix string) {
+	descriptions := map[string]string{}
+	for _, path := range visiblePaths(commands) {
+		if strings.HasPrefix(path, prefix) {
+			descriptions[path] = commands[path].Description
+		}
+	}
+	if prefix == "" {
+		maps.Copy(descriptions, builtinCommands)
+	}
+
+	selected := make([]string, 0, len(descriptions))
+	var maxLen int
+	for path := range descriptions {
+		selected = append(selected, path)
+		if len(path) > maxLen {
+			maxLen = len(path)
+		}
+	}
+	sort.Strings(selected)
+
+	fmt.Fprintln(w, "\n Available commands:")
+	fmt.Fprintln(w)
+	for _, path := range selected {
+		fmt.Fprintf(w, fmt.Sprintf(`   %%-%ds`, maxLen)+"  %s\n", path, descriptions[path])
+	}
+	fmt.Fprintln(w, "")
+}
+
+// printHelp prints the description, flags, dependencies, examples and tags of the command.
+func printHelp(w io.Writer, path string, cmd types.Command, commands map[string]types.Command) error {
+	fmt.Fprintf(w, "\n %s", path)
+	if cmd.Description != "" {
+		fmt.Fprintf(w, " - %s", cmd.Description)
+	}
+	fmt.Fprintln(w)
+
+	if cmd.LongDescription != "" {
+		fmt.Fprintln(w)
+		for _, line := range strings.Split(strings.TrimSpace(cmd.LongDescription), "\n") {
+			fmt.Fprintln(w, strings.TrimRight("   "+line, " "))
+		}
+	}
+
+	if len(cmd.Flags) > 0 {
+		flags, err := newFlagSet(path, cmd)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, "\n Flags:")
+		fmt.Fprintln(w)
+		for _, line := range strings.Split(strings.TrimRight(flags.FlagUsages(), "\n"), "\n") {
+			fmt.Fprintln(w, " "+line)
+		}
+	}
+
+	printDependencies(w, cmd, commands)
+
+	if len(cmd.Examples) > 0 {
+		fmt.Fprintln(w, "\n Examples:")
+		fmt.Fprintln(w)
+		for _, example := range cmd.Examples {
+			fmt.Fprintln(w, "   "+example)
+		}
+	}
+
+	if len(cmd.Aliases) > 0 {
+		fmt.Fprintf(w, "\n Aliases: %s\n", strings.Join(cmd.Aliases, ", "))
+	}
+
+	if cmd.Deprecated != "" {
+		fmt.Fprintf(w, "\n Deprecated: %s\n", cmd.Deprecated)
+	}
+
+	if len(cmd.Tags) > 0 {
+		fmt.Fprintf(w, "\n Tags: %s\n", strings.Join(cmd.Tags, ", "))
+	}
+
+	if hasSubtree(commands, path+"/") {
+		listCommands(w, commands, path+"/")
+		return nil
+	}
+	fmt.Fprintln(w, "")
+	return nil
+}
+
+// printDependencies prints the dependencies declared by the command. Dependencies requested by its function
+// are not printed, because discovering them requires executing the command.
+func printDependencies(w io.Writer, cmd types.Command, commands map[string]types.Command) {
+	if len(cmd.Deps) == 0 {
+		return
+	}
+
+	e := newExecutor(commands, executorConfig{})
+	deps := make([]string, 0, len(cmd.Deps))
+	var maxLen int
+	for _, dep := range cmd.Deps {
+		path := e.name(reflect.ValueOf(dep))
+		deps = append(deps, path)
+		if len(path) > maxLen {
+			maxLen = len(path)
+		}
+	}
+
+	fmt.Fprintln(w, "\n Dependencies:")
+	fmt.Fprintln(w)
+	for _, dep := range deps {
+		fmt.Fprintln(w, strings.TrimRight(fmt.Sprintf(fmt.Sprintf(`   %%-%ds`, maxLen)+"  %s", dep,
+			commands[dep].Description), " "))
+	}
+}
@@ -0,0 +1,137 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/outofforest/build/v2/pkg/types"
+)
+
+var helpCommands = map[string]types.Command{
+	"deploy": {Description: "Deploys everything"},
+	"deploy/db": {
+		Description:     "Deploys the database",
+		LongDescription: "Deploys the database to the environment.\n\nExisting data are preserved.",
+		Examples:        []string{"projname deploy/db --env=staging"},
+		Tags:            []string{"db", "deploy"},
+		Flags: []types.Flag{
+			{Name: "env", Default: "dev", Help: "Environment to deploy to"},
+		},
+	},
+	"deploy/db/migrate": {Description: "Migrates the database"},
+	"test":              {Description: "Runs tests"},
+}
+
+func TestHelp(t *testing.T) {
+	buf := &bytes.Buffer{}
+	require.NoError(t, help(buf, helpCommands, []string{"deploy/db"}))
+	assert.Equal(t, `
+ deploy/db - Deploys the database
+
+   Deploys the database to the environment.
+
+   Existing data are preserved.
+
+ Flags:
+
+       --env string   Environment to deploy to (default "dev")
+
+ Examples:
+
+   projname deploy/db --env=staging
+
+ Tags: db, deploy
+
+ Available commands:
+
+   deploy/db/migrate  Migrates the database
+
+`, buf.String())
+}
+
+func TestHelpListsDependencies(t *testing.T) {
+	buf := &bytes.Buffer{}
+	var executed bool
+	require.NoError(t, help(buf, map[string]types.Command{
+		"a": {
+			Fn: func(_ context.Context, _ types.DepsFunc) error {
+				executed = true
+				return nil
+			},
+			Description: "Command A",
+			Deps:        []types.CommandFunc{cmdAA, cmdAB},
+		},
+		"a/aa": {Fn: cmdAA, Description: "Command AA"},
+	}, []string{"a"}))
+	assert.False(t, executed)
+	assert.Equal(t, `
+ a - Command A
+
+ Dependencies:
+
+   a/aa                                   Command AA
+   github.com/outofforest/build/v2.cmdAB
+
+ Available commands:
+
+   a/aa  Command AA
+
+`, buf.String())
+}
+
+func TestHelpListsSubtree(t *testing.T) {
+	buf := &bytes.Buffer{}
+	require.NoError(t, help(buf, map[string]types.Command{
+		"deploy/db":  {Description: "Deploys the database"},
+		"deploy/app": {Description: "Deploys the application"},
+		"test":       {Description: "Runs tests"},
+	}, []string{"deploy"}))
+	assert.Equal(t, `
+ Available commands:
+
+   deploy/app  Deploys the application
+   deploy/db   Deploys the database
+
+`, buf.String())
+}
+
+func TestHelpOfMissingCommand(t *testing.T) {
+	require.EqualError(t, help(&bytes.Buffer{}, helpCommands, []string{"build"}), "build: command build does not exist")
+}
+
+func TestSubtreePrefix(t *testing.T) {
+	prefix, ok := subtreePrefix(helpCommands, []string{"deploy/"})
+	assert.True(t, ok)
+	assert.Equal(t, "deploy/", prefix)
+
+	_, ok = subtreePrefix(helpCommands, []string{"test/"})
+	assert.False(t, ok)
+
+	_, ok = subtreePrefix(helpCommands, []string{"deploy"})
+	assert.False(t, ok)
+
+	_, ok = subtreePrefix(helpCommands, []string{"deploy/", "test"})
+	assert.False(t, ok)
+}
+
+func TestBuiltinCommand(t *testing.T) {
+	builtin, args, ok := builtinCommand(globalFlags(), []string{"-j", "2", "help", "deploy/db"})
+	require.True(t, ok)
+	assert.Equal(t, helpCommand, builtin)
+	assert.Equal(t, []string{"deploy/db"}, args)
+
+	builtin, args, ok = builtinCommand(globalFlags(), []string{"completion", "bash"})
+	require.True(t, ok)
+	assert.Equal(t, completionCommand, builtin)
+	assert.Equal(t, []string{"bash"}, args)
+
+	_, _, ok = builtinCommand(globalFlags(), []string{"deploy", "help"})
+	assert.False(t, ok)
+
+	_, _, ok = builtinCommand(globalFlags(), []string{"--unknown", "help"})
+	assert.False(t, ok)
+}
@@ -0,0 +1,302 @@
+package build
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/outofforest/build/v2/pkg/tools"
+	"github.com/outofforest/build/v2/pkg/types"
+	"github.com/outofforest/logger"
+)
+
+const missingFile = "missing"
+
+// callIncremental executes the command only if it is not up to date and its outputs can't be restored from cache.
+func (e *executor) callIncremental(
+	ctx context.Context,
+	inv *invocation,
+	cmd types.Command,
+	deps types.DepsFunc,
+) error {
+	log := logger.Get(ctx)
+
+	var key string
+	if !e.config.Force {
+		upToDate, err := isUpToDate(ctx, inv.name, cmd)
+		if err != nil {
+			return err
+		}
+		if upToDate {
+			inv.skipReason = skipReasonUpToDate
+			log.Info("Command is up to date, skipping")
+			return nil
+		}
+	}
+
+	if len(cmd.Outputs) > 0 && !e.config.DryRun {
+		var err error
+		key, err = cacheKey(ctx, inv.name, cmd)
+		if err != nil {
+			return err
+		}
+		if !e.config.Force {
+			restored, err := e.restoreFromCache(ctx, key, cmd.Outputs)
+			if err != nil {
+				return err
+			}
+			if restored {
+				inv.skipReason = skipReasonRestored
+				log.Info("Outputs restored from cache, skipping")
+				return storeFingerprint(ctx, inv.name, cmd)
+			}
+		}
+	}
+
+	bodyDeps := func(fns ...types.CommandFunc) {
+		log.Warn("Incremental command requests dependencies while running, they are not executed " +
+			"if it is skipped, declare them in Deps")
+		deps(fns...)
+	}
+	if err := inv.cmd(ctx, bodyDeps); err != nil || e.config.DryRun {
+		return err
+	}
+
+	if key != "" {
+		e.storeInCache(ctx, key, cmd.Outputs)
+	}
+	return storeFingerprint(ctx, inv.name, cmd)
+}
+
+// restoreFromCache restores outputs from the local cache. If they are not there, remote cache is checked.
+func (e *executor) restoreFromCache(ctx context.Context, key string, outputs []string) (bool, error) {
+	cache := e.getCache(ctx)
+	restored, err := cache.Restore(key, outputs)
+	if err != nil || restored || e.config.RemoteCache == nil {
+		return restored, err
+	}
+
+	// Remote cache is an optimization only, so build continues if it fails.
+	fetched, err := fetchFromRemoteCache(ctx, e.config.RemoteCache, cache, key)
+	if err != nil {
+		logger.Get(ctx).Warn("Fetching outputs from remote cache failed", zap.Error(err))
+		return false, nil
+	}
+	if !fetched {
+		return false, nil
+	}
+	return cache.Restore(key, outputs)
+}
+
+// storeInCache stores outputs in the local cache, and the remote one if it is configured.
+func (e *executor) storeInCache(ctx context.Context, key string, outputs []string) {
+	// Cache is an optimization only, so command succeeds even if its outputs can't be stored.
+	cache := e.getCache(ctx)
+	checksum, err := cache.Store(key, outputs)
+	if err != nil {
+		logger.Get(ctx).Warn("Storing outputs in cache failed", zap.Error(err))
+		return
+	}
+	if e.config.RemoteCache == nil {
+		return
+	}
+
+	if err := pushToRemoteCache(ctx, e.config.RemoteCache, cache, key, checksum); err != nil {
+		logger.Get(ctx).Warn("Storing outputs in remote cache failed", zap.Error(err))
+	}
+}
+
+// isIncremental returns true if command declares its inputs.
+func isIncremental(cmd types.Command) bool {
+	return len(cmd.Inputs) > 0
+}
+
+// isUpToDate checks if neither inputs nor outputs of the command changed since its last successful execution.
+func isUpToDate(ctx context.Context, path string, cmd types.Command) (bool, error) {
+	stored, err := os.ReadFile(fingerprintFile(ctx, path))
+	switch {
+	case err == nil:
+	case errors.Is(err, os.ErrNotExist):
+		return false, nil
+	default:
+		return false, errors.WithStack(err)
+	}
+
+	fingerprint, err := commandFingerprint(ctx, cmd)
+	if err != nil {
+		return false, err
+	}
+	return string(stored) == fingerprint, nil
+}
+
+// storeFingerprint stores the fingerprint of inputs and outputs of successfully executed command.
+func storeFingerprint(ctx context.Context, path string, cmd types.Command) error {
+	fingerprint, err := commandFingerprint(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	file := fingerprintFile(ctx, path)
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.WriteFile(file, []byte(fingerprint), 0o600))
+}
+
+func commandFingerprint(ctx context.Context, cmd types.Command) (string, error) {
+	inputsHash, err := inputsFingerprint(cmd.Inputs)
+	if err != nil {
+		return "", err
+	}
+	outputsHash, err := filesFingerprint(cmd.Outputs)
+	if err != nil {
+		return "", err
+	}
+	flagsHash := sha256.Sum256([]byte(flagsFingerprint(ctx, cmd)))
+	return inputsHash + ":" + outputsHash + ":" + hex.EncodeToString(flagsHash[:]), nil
+}
+
+// inputsFingerprint computes the hash of all the files matching the glob patterns.
+func inputsFingerprint(patterns []string) (string, error) {
+	files := []string{}
+	for _, pattern := range patterns {
+		matches, err := glob(pattern)
+		if err != nil {
+			return "", err
+		}
+		files = append(files, matches...)
+	}
+	return filesFingerprint(files)
+}
+
+// filesFingerprint computes the hash of files, directories are hashed recursively.
+// Missing files are hashed too, so their later creation changes the fingerprint.
+func filesFingerprint(paths []string) (string, error) {
+	checksums := map[string]string{}
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+				checksums[path] = missingFile
+				return nil
+			case err != nil:
+				return errors.WithStack(err)
+			case d.IsDir():
+				return nil
+			}
+
+			checksum, err := tools.Checksum(path)
+			if err != nil {
+				return err
+			}
+			checksums[path] = checksum
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	files := make([]string, 0, len(checksums))
+	for file := range checksums {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	hasher := sha256.New()
+	for _, file := range files {
+		fmt.Fprintf(hasher, "%s %s\n", filepath.ToSlash(file), checksums[file])
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// fingerprintFile returns path of the file storing the fingerprint of the command.
+// Absolute path of the working directory is taken into account, so many clones of the same repository
+// don't interfere.
+func fingerprintFile(ctx context.Context, path string) string {
+	wd, _ := os.Getwd()
+	hash := sha256.Sum256([]byte(wd + "\x00" + path))
+	return filepath.Join(tools.EnvDir(ctx), "fingerprints", hex.EncodeToString(hash[:]))
+}
+
+// glob returns files matching the pattern. In addition to the syntax supported by `filepath.Match`,
+// `**` matches any number of directories.
+func glob(pattern string) ([]string, error) {
+	pattern = filepath.Clean(pattern)
+	if !strings.Contains(pattern, "**") {
+		matches, err := filepath.Glob(pattern)
+		return matches, errors.WithStack(err)
+	}
+
+	segments := strings.Split(filepath.ToSlash(pattern), "/")
+	var root string
+	for i, segment := range segments {
+		if !strings.ContainsAny(segment, "*?[\\") {
+			continue
+		}
+		switch {
+		case i == 0:
+			root = "."
+		case i == 1 && filepath.IsAbs(pattern):
+			// Leading separator must be preserved, otherwise absolute root becomes the relative one.
+			root = filepath.VolumeName(pattern) + string(filepath.Separator)
+		default:
+			root = filepath.FromSlash(strings.Join(segments[:i], "/"))
+		}
+		segments = segments[i:]
+		break
+	}
+
+	matches := []string{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			return nil
+		case err != nil:
+			return errors.WithStack(err)
+		case d.IsDir():
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if matchSegments(segments, strings.Split(filepath.ToSlash(rel), "/")) {
+			matches = append(matches, path)
+		}
+		return nil
+	})
+	return matches, err
+}
+
+func matchSegments(pattern, path []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(path); i++ {
+				if matchSegments(pattern[1:], path[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(path) == 0 {
+			return false
+		}
+		if ok, _ := filepath.Match(pattern[0], path[0]); !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		path = path[1:]
+	}
+	return len(path) == 0
+}
@@ -0,0 +1,138 @@
+package build
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/outofforest/build/v2/pkg/tools"
+	"github.com/outofforest/build/v2/pkg/types"
+)
+
+func TestIncrementalCommand(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Chdir(t.TempDir())
+	ctx := tools.WithVersion(tools.WithName(tCtx, "test"), "v1")
+
+	require.NoError(t, os.MkdirAll("src/pkg", 0o700))
+	require.NoError(t, os.WriteFile("src/pkg/input.txt", []byte("input"), 0o600))
+
+	var executed int
+	commands := map[string]types.Command{
+		"cmd": {
+			Fn: func(_ context.Context, _ types.DepsFunc) error {
+				executed++
+				return os.WriteFile("output.txt", []byte("output"), 0o600)
+			},
+			Inputs:  []string{"src/**/*.txt"},
+			Outputs: []string{"output.txt"},
+		},
+	}
+	exe := func(config executorConfig) {
+		config.Jobs = 1
+		require.NoError(t, execute(ctx, commands, []string{"cmd"}, config))
+	}
+
+	exe(executorConfig{})
+	assert.Equal(t, 1, executed)
+
+	exe(executorConfig{})
+	assert.Equal(t, 1, executed)
+
+	exe(executorConfig{Force: true})
+	assert.Equal(t, 2, executed)
+
+	require.NoError(t, os.WriteFile("src/pkg/input.txt", []byte("changed"), 0o600))
+	exe(executorConfig{})
+	assert.Equal(t, 3, executed)
+
+	require.NoError(t, os.WriteFile("src/input2.txt", []byte("new"), 0o600))
+	exe(executorConfig{})
+	assert.Equal(t, 4, executed)
+
+	// Removed output is restored from cache.
+	require.NoError(t, os.Remove("output.txt"))
+	exe(executorConfig{})
+	assert.Equal(t, 4, executed)
+	assert.FileExists(t, "output.txt")
+
+	exe(executorConfig{})
+	assert.Equal(t, 4, executed)
+}
+
+func TestIncrementalCommandWithDeps(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Chdir(t.TempDir())
+	ctx := tools.WithVersion(tools.WithName(tCtx, "test"), "v1")
+
+	var generated, executed int
+	content := "v1"
+	gen := func(_ context.Context, _ types.DepsFunc) error {
+		generated++
+		return os.WriteFile("generated.txt", []byte(content), 0o600)
+	}
+	commands := map[string]types.Command{
+		"cmd": {
+			Fn: func(_ context.Context, _ types.DepsFunc) error {
+				executed++
+				return nil
+			},
+			Deps:   []types.CommandFunc{gen},
+			Inputs: []string{"generated.txt"},
+		},
+	}
+	exe := func() {
+		require.NoError(t, execute(ctx, commands, []string{"cmd"}, executorConfig{Jobs: 1}))
+	}
+
+	exe()
+	assert.Equal(t, 1, generated)
+	assert.Equal(t, 1, executed)
+
+	// Dependencies are executed even if command is up to date.
+	exe()
+	assert.Equal(t, 2, generated)
+	assert.Equal(t, 1, executed)
+
+	// Inputs are checked once dependencies regenerate them.
+	content = "v2"
+	exe()
+	assert.Equal(t, 3, generated)
+	assert.Equal(t, 2, executed)
+}
+
+func TestGlob(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	for _, file := range []string{"a.go", "a.txt", "dir/b.go", "dir/sub/c.go", "other/d.go"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0o700))
+		require.NoError(t, os.WriteFile(file, nil, 0o600))
+	}
+
+	matches, err := glob("**/*.go")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a.go", "dir/b.go", "dir/sub/c.go", "other/d.go"}, matches)
+
+	matches, err = glob("dir/**/*.go")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"dir/b.go", "dir/sub/c.go"}, matches)
+
+	matches, err = glob("*.go")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a.go"}, matches)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	matches, err = glob(filepath.Join(wd, "dir", "**", "*.go"))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{filepath.Join(wd, "dir", "b.go"), filepath.Join(wd, "dir", "sub", "c.go")},
+		matches)
+
+	matches, err = glob("missing/**")
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+}
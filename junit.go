@@ -0,0 +1,199 @@
+package build
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/outofforest/build/v2/pkg/types"
+)
+
+// junitDepsSuite is the name of the test suite grouping commands which are not registered.
+const junitDepsSuite = "dependencies"
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     float64          `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      float64         `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func newJUnitReport(commands map[string]types.Command) *junitReport {
+	return &junitReport{
+		commands: commands,
+		output:   map[string]*strings.Builder{},
+	}
+}
+
+// junitReport collects results of commands to report them in JUnit XML format.
+// Each command is reported as a test case, grouped in test suites by the top-level segment of its path.
+// Log entries of the command and its captured output are reported as the output of the test case.
+type junitReport struct {
+	NopObserver
+
+	commands map[string]types.Command
+
+	mu        sync.Mutex
+	testCases []junitTestCase
+	output    map[string]*strings.Builder
+	duration  time.Duration
+}
+
+// CommandSkipped is called when command is skipped.
+func (jr *junitReport) CommandSkipped(event CommandEvent) {
+	jr.add(event, nil, &junitMessage{Message: event.Reason})
+}
+
+// CommandSucceeded is called when command completes successfully.
+func (jr *junitReport) CommandSucceeded(event CommandEvent) {
+	jr.add(event, nil, nil)
+}
+
+// CommandFailed is called when command fails.
+func (jr *junitReport) CommandFailed(event CommandEvent) {
+	jr.add(event, &junitMessage{Message: event.Err.Error(), Text: fmt.Sprintf("%+v", event.Err)}, nil)
+}
+
+// BuildFinished is called once all the commands complete.
+func (jr *junitReport) BuildFinished(event BuildEvent) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+
+	jr.duration = event.Duration
+}
+
+// LogWritten is called when log entry is written.
+func (jr *junitReport) LogWritten(entry zapcore.Entry, command string, fields map[string]any) {
+	if command == "" {
+		return
+	}
+
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+
+	output, exists := jr.output[command]
+	if !exists {
+		output = &strings.Builder{}
+		jr.output[command] = output
+	}
+	fmt.Fprintf(output, "%s\t%s\t%s", entry.Time.Format(time.RFC3339Nano), entry.Level, entry.Message)
+	if len(fields) > 0 {
+		if fieldsJSON, err := json.Marshal(fields); err == nil {
+			fmt.Fprintf(output, "\t%s", fieldsJSON)
+		}
+	}
+	output.WriteString("\n")
+}
+
+func (jr *junitReport) add(event CommandEvent, failure, skipped *junitMessage) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+
+	jr.testCases = append(jr.testCases, junitTestCase{
+		Name:      event.Path,
+		ClassName: jr.suite(event.Path),
+		Time:      event.Duration.Seconds(),
+		Failure:   failure,
+		Skipped:   skipped,
+		SystemOut: event.Output,
+	})
+}
+
+// suite returns the name of the test suite the command belongs to.
+func (jr *junitReport) suite(path string) string {
+	if _, exists := jr.commands[path]; !exists {
+		return junitDepsSuite
+	}
+	suite, _, _ := strings.Cut(path, "/")
+	return suite
+}
+
+// Report returns the report.
+func (jr *junitReport) Report() junitTestSuites {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+
+	report := junitTestSuites{Time: jr.duration.Seconds()}
+	suites := map[string]*junitTestSuite{}
+	for _, tc := range jr.testCases {
+		if output, exists := jr.output[tc.Name]; exists {
+			tc.SystemOut = output.String() + tc.SystemOut
+		}
+
+		suite, exists := suites[tc.ClassName]
+		if !exists {
+			suite = &junitTestSuite{Name: tc.ClassName}
+			suites[tc.ClassName] = suite
+		}
+		suite.TestCases = append(suite.TestCases, tc)
+		suite.Tests++
+		suite.Time += tc.Time
+		report.Tests++
+		switch {
+		case tc.Failure != nil:
+			suite.Failures++
+			report.Failures++
+		case tc.Skipped != nil:
+			suite.Skipped++
+			report.Skipped++
+		}
+	}
+
+	names := make([]string, 0, len(suites))
+	for name := range suites {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		report.Suites = append(report.Suites, *suites[name])
+	}
+	return report
+}
+
+func writeJUnit(file string, report junitTestSuites) error {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(xml.Header); err != nil {
+		return errors.WithStack(err)
+	}
+	enc := xml.NewEncoder(f)
+	enc.Indent("", "  ")
+	return errors.WithStack(enc.Encode(report))
+}
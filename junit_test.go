@@ -0,0 +1,84 @@
+package build
+
+import (
+	"context"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/outofforest/build/v2/pkg/types"
+	"github.com/outofforest/logger"
+)
+
+func TestJUnitReport(t *testing.T) {
+	commands := map[string]types.Command{
+		"lint/go": {Fn: func(ctx context.Context, _ types.DepsFunc) error {
+			logger.Get(ctx).Info("Linting")
+			return nil
+		}},
+		"test/go": {Fn: func(_ context.Context, deps types.DepsFunc) error {
+			deps(cmdB)
+			return nil
+		}},
+		"test/unit": {Fn: cmdHA},
+	}
+	junit := newJUnitReport(commands)
+
+	core, _ := observer.New(zapcore.InfoLevel)
+	ctx := logger.WithLogger(context.Background(), observeLogs(zap.New(core), junit))
+
+	err := execute(ctx, commands, []string{"lint/go", "test/unit", "test/go"}, executorConfig{
+		Jobs:      1,
+		KeepGoing: true,
+		Observers: []Observer{junit},
+	})
+	require.Error(t, err)
+
+	report := junit.Report()
+	assert.Equal(t, 4, report.Tests)
+	assert.Equal(t, 1, report.Failures)
+	assert.Equal(t, 1, report.Skipped)
+	assert.Positive(t, report.Time)
+
+	require.Len(t, report.Suites, 3)
+	assert.Equal(t, "dependencies", report.Suites[0].Name)
+	assert.Equal(t, "lint", report.Suites[1].Name)
+	assert.Equal(t, "test", report.Suites[2].Name)
+
+	deps := report.Suites[0].TestCases
+	require.Len(t, deps, 1)
+	assert.Equal(t, "github.com/outofforest/build/v2.cmdB", deps[0].Name)
+	require.NotNil(t, deps[0].Failure)
+	assert.Equal(t, "error", deps[0].Failure.Message)
+
+	lint := report.Suites[1].TestCases
+	require.Len(t, lint, 1)
+	assert.Equal(t, "lint/go", lint[0].Name)
+	assert.Equal(t, "lint", lint[0].ClassName)
+	assert.Nil(t, lint[0].Failure)
+	assert.Contains(t, lint[0].SystemOut, "info\tLinting\n")
+
+	tests := report.Suites[2].TestCases
+	require.Len(t, tests, 2)
+	assert.Equal(t, "test/unit", tests[0].Name)
+	assert.Equal(t, "test/go", tests[1].Name)
+	require.NotNil(t, tests[1].Skipped)
+	assert.Equal(t, "dependency failed", tests[1].Skipped.Message)
+
+	file := filepath.Join(t.TempDir(), "junit.xml")
+	require.NoError(t, writeJUnit(file, report))
+	content, err := os.ReadFile(file)
+	require.NoError(t, err)
+
+	var stored junitTestSuites
+	require.NoError(t, xml.Unmarshal(content, &stored))
+	assert.Equal(t, report.Tests, stored.Tests)
+	assert.Len(t, stored.Suites, 3)
+}
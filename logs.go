@@ -0,0 +1,66 @@
+package build
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// logObserver is notified about log entries.
+type logObserver interface {
+	// LogWritten is called when log entry is written. Command is taken from the `command` field,
+	// which is removed from the other fields.
+	LogWritten(entry zapcore.Entry, command string, fields map[string]any)
+}
+
+// observeLogs returns logger notifying the observer about its entries.
+func observeLogs(log *zap.Logger, o logObserver) *zap.Logger {
+	return log.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(core, &observingCore{LevelEnabler: core, observer: o})
+	}))
+}
+
+// redirectLogs returns logger passing its entries to the observer instead of printing them.
+func redirectLogs(log *zap.Logger, o logObserver) *zap.Logger {
+	return log.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &observingCore{LevelEnabler: core, observer: o}
+	}))
+}
+
+// observingCore is the logger core passing entries to the observer.
+type observingCore struct {
+	zapcore.LevelEnabler
+
+	observer logObserver
+	fields   []zapcore.Field
+}
+
+func (c *observingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &observingCore{
+		LevelEnabler: c.LevelEnabler,
+		observer:     c.observer,
+		fields:       append(append([]zapcore.Field{}, c.fields...), fields...),
+	}
+}
+
+func (c *observingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *observingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, field := range append(append([]zapcore.Field{}, c.fields...), fields...) {
+		field.AddTo(enc)
+	}
+
+	command, _ := enc.Fields["command"].(string)
+	delete(enc.Fields, "command")
+	c.observer.LogWritten(entry, command, enc.Fields)
+	return nil
+}
+
+func (c *observingCore) Sync() error {
+	return nil
+}
@@ -2,17 +2,20 @@ package build
 
 import (
 	"context"
+	goerrors "errors"
 	"fmt"
+	"io"
 	"maps"
 	"os"
 	"path/filepath"
-	"reflect"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/samber/lo"
+	"github.com/spf13/pflag"
 
 	"github.com/outofforest/build/v2/pkg/tools"
 	"github.com/outofforest/build/v2/pkg/types"
@@ -20,35 +23,177 @@ import (
 	"github.com/outofforest/run"
 )
 
-const maxStack = 100
-
-var defaultCommandRegistry = newCommandRegistry()
+var (
+	defaultCommandRegistry = newCommandRegistry()
+	defaultRemoteCache     RemoteCache
+	defaultObservers       []Observer
+)
 
 // Main receives configuration and runs registeredCommands.
 func Main(name, version string) {
 	commands := defaultCommandRegistry.commands
-	run.New().Run(context.Background(), "build", func(ctx context.Context) error {
+	run.New().Run(context.Background(), "build", func(ctx context.Context) (retErr error) {
 		flags := logger.Flags(logger.DefaultConfig, "build")
-		if err := flags.Parse(os.Args[1:]); err != nil {
-			return err
+		flags.IntP("jobs", "j", runtime.NumCPU(), "Maximum number of commands executed in parallel")
+		flags.Bool("dry-run", false, "Prints the execution plan without running side effects of commands")
+		flags.Bool("force", false, "Executes commands even if they are up to date")
+		flags.BoolP("keep-going", "k", false, "Keeps executing commands not depending on the failed ones")
+		flags.String("remote-cache", "", "URL of the remote build cache")
+		flags.String("trace", "", "File to store the trace of the execution in, in Chrome Trace Event Format")
+		flags.String("json-events", "", "File to store the events of the execution in, as newline-delimited JSON, "+
+			"- stands for standard output")
+		flags.String("junit", "", "File to store the report of the execution in, in JUnit XML format")
+		flags.Bool("buffer-output", false, "Prints output of the command only if it fails")
+		flags.Bool("progress", true, "Displays progress of the execution if standard output is a terminal")
+
+		ctx = tools.WithVersion(tools.WithName(ctx, name), version)
+
+		if builtin, args, ok := builtinCommand(flags, os.Args[1:]); ok {
+			switch builtin {
+			case completionCommand:
+				if len(args) != 1 {
+					return errors.Errorf("build: usage: %s completion bash|zsh|fish", name)
+				}
+				return printCompletionScript(os.Stdout, args[0], name)
+			case completeCommand:
+				printCompletions(os.Stdout, completions(ctx, flags, commands, args))
+				return nil
+			default:
+				return help(os.Stdout, commands, args)
+			}
+		}
+
+		if prefix, ok := subtreePrefix(commands, os.Args[1:]); ok {
+			listCommands(os.Stdout, commands, prefix)
+			return nil
 		}
 
 		if isAutocomplete() {
-			autocompleteDo(commands)
+			autocompleteDo(ctx, flags, commands)
 			return nil
 		}
 
-		if len(flags.Args()) == 0 {
-			listCommands(commands)
+		paths, commandFlags, err := parseArgs(flags, commands, os.Args[1:])
+		if err != nil {
+			return err
+		}
+
+		if len(paths) == 0 {
+			listCommands(os.Stdout, commands, "")
 			return nil
 		}
 
-		ctx = tools.WithVersion(tools.WithName(ctx, name), version)
+		// Patterns are expanded here, so requested commands are known before the output is configured.
+		paths, err = expandPaths(commands, paths)
+		if err != nil {
+			return err
+		}
+
+		jobs := lo.Must(flags.GetInt("jobs"))
+		if jobs < 1 {
+			return errors.Errorf("build: number of jobs must be positive, %d provided", jobs)
+		}
+
+		dryRun := lo.Must(flags.GetBool("dry-run"))
+		force := lo.Must(flags.GetBool("force"))
+		keepGoing := lo.Must(flags.GetBool("keep-going"))
+		bufferOutput := lo.Must(flags.GetBool("buffer-output"))
+		traceFile := lo.Must(flags.GetString("trace"))
+		remoteCache := defaultRemoteCache
+		if remoteCacheURL := lo.Must(flags.GetString("remote-cache")); remoteCacheURL != "" {
+			remoteCache = NewHTTPCache(remoteCacheURL)
+		}
+
 		changeWorkingDir()
-		return execute(ctx, commands, flags.Args())
+
+		observers := append([]Observer{}, defaultObservers...)
+
+		// If events are printed to standard output, output of commands is redirected, so events may be parsed.
+		jsonEventsFile := lo.Must(flags.GetString("json-events"))
+		var stdout, stderr io.Writer
+		out := io.Writer(os.Stdout)
+		if jsonEventsFile == "-" {
+			stdout = os.Stderr
+			out = os.Stderr
+		}
+
+		var ui *progressUI
+		if showProgress(flags, commands, paths) {
+			ui = newProgressUI(os.Stdout)
+			stdout = ui
+			stderr = ui
+			observers = append(observers, ui)
+			ctx = logger.WithLogger(ctx, redirectLogs(logger.Get(ctx), ui))
+		}
+
+		if jsonEventsFile != "" {
+			events, err := openJSONEvents(jsonEventsFile)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := events.Close(); err != nil {
+					retErr = goerrors.Join(retErr, err)
+				}
+			}()
+
+			observers = append(observers, events)
+			ctx = logger.WithLogger(ctx, observeLogs(logger.Get(ctx), events))
+		}
+
+		junitFile := lo.Must(flags.GetString("junit"))
+		junit := newJUnitReport(commands)
+		if junitFile != "" {
+			observers = append(observers, junit)
+			ctx = logger.WithLogger(ctx, observeLogs(logger.Get(ctx), junit))
+		}
+
+		e := newExecutor(commands, executorConfig{
+			Jobs:          jobs,
+			DryRun:        dryRun,
+			Force:         force,
+			RemoteCache:   remoteCache,
+			KeepGoing:     keepGoing,
+			Flags:         commandFlags,
+			Observers:     observers,
+			BufferOutput:  bufferOutput,
+			CaptureOutput: junitFile != "",
+			Stdout:        stdout,
+			Stderr:        stderr,
+		})
+
+		if ui != nil {
+			ui.Start()
+		}
+		err = e.Execute(ctx, paths)
+		if ui != nil {
+			ui.Stop()
+		}
+
+		if dryRun {
+			printPlan(out, e.Plan())
+		} else if timings := e.Timings(); showTimings(commands, paths, timings) {
+			printTimings(os.Stderr, timings, e.CriticalPath())
+		}
+		if traceFile != "" {
+			if err2 := writeTrace(traceFile, e.Trace()); err == nil {
+				err = err2
+			}
+		}
+		if junitFile != "" {
+			if err2 := writeJUnit(junitFile, junit.Report()); err == nil {
+				err = err2
+			}
+		}
+		return err
 	})
 }
 
+// UseRemoteCache sets the remote cache used by default to share outputs of commands.
+func UseRemoteCache(cache RemoteCache) {
+	defaultRemoteCache = cache
+}
+
 // RegisterCommands registers registeredCommands.
 func RegisterCommands(commands ...map[string]types.Command) {
 	if err := defaultCommandRegistry.RegisterCommands(commands); err != nil {
@@ -56,144 +201,74 @@ func RegisterCommands(commands ...map[string]types.Command) {
 	}
 }
 
-func execute(ctx context.Context, commands map[string]types.Command, paths []string) error {
-	pathsTrimmed := make([]string, 0, len(paths))
-	for _, p := range paths {
-		if p[len(p)-1] == '/' {
-			p = p[:len(p)-1]
-		}
-		pathsTrimmed = append(pathsTrimmed, p)
-	}
+// RegisterObservers registers observers notified about the progress of the execution.
+func RegisterObservers(observers ...Observer) {
+	defaultObservers = append(defaultObservers, observers...)
+}
 
-	executed := map[reflect.Value]bool{}
-	stack := map[reflect.Value]bool{}
-
-	errReturn := errors.New("return")
-	errChan := make(chan error, 1)
-	var depsFunc types.DepsFunc
-	worker := func(queue <-chan types.CommandFunc, done chan<- struct{}) {
-		defer close(done)
-		defer func() {
-			if r := recover(); r != nil {
-				var err error
-				if err2, ok := r.(error); ok {
-					if errors.Is(err2, errReturn) {
-						return
-					}
-					err = err2
-				} else {
-					err = errors.Errorf("command panicked: %v", r)
-				}
-				errChan <- err
-				close(errChan)
-			}
-		}()
-		for {
-			select {
-			case <-ctx.Done():
-				errChan <- ctx.Err()
-				close(errChan)
-				return
-			case cmd, ok := <-queue:
-				if !ok {
-					return
-				}
-				cmdValue := reflect.ValueOf(cmd)
-				if executed[cmdValue] {
-					continue
-				}
-				var err error
-				switch {
-				case stack[cmdValue]:
-					err = errors.New("build: dependency cycle detected")
-				case len(stack) >= maxStack:
-					err = errors.New("build: maximum length of stack reached")
-				default:
-					stack[cmdValue] = true
-					err = cmd(ctx, depsFunc)
-					delete(stack, cmdValue)
-					executed[cmdValue] = true
-				}
-				if err != nil {
-					errChan <- err
-					close(errChan)
-					return
-				}
-			}
-		}
+// builtinCommand returns the built-in command and its arguments, if it is the first argument following
+// global flags.
+func builtinCommand(globalFlags *pflag.FlagSet, args []string) (string, []string, bool) {
+	flags := pflag.NewFlagSet("", pflag.ContinueOnError)
+	flags.AddFlagSet(globalFlags)
+	flags.SetInterspersed(false)
+	flags.SetOutput(io.Discard)
+	flags.Usage = func() {}
+	// Errors are reported once the command line is parsed again, together with the flags of commands.
+	if err := flags.Parse(args); err != nil || flags.NArg() == 0 {
+		return "", nil, false
 	}
-	depsFunc = func(deps ...types.CommandFunc) {
-		queue := make(chan types.CommandFunc)
-		done := make(chan struct{})
-		go worker(queue, done)
-	loop:
-		for _, d := range deps {
-			select {
-			case <-done:
-				break loop
-			case queue <- d:
-			}
-		}
-		close(queue)
-		<-done
-		if len(errChan) > 0 {
-			panic(errReturn)
-		}
+
+	switch args = flags.Args(); args[0] {
+	case completionCommand, completeCommand, helpCommand:
+		return args[0], args[1:], true
+	default:
+		return "", nil, false
 	}
+}
 
-	initDeps := make([]types.CommandFunc, 0, len(pathsTrimmed))
-	for _, p := range pathsTrimmed {
-		cmd, exists := commands[p]
-		if !exists {
-			return errors.Errorf("build: command %s does not exist", p)
-		}
-		initDeps = append(initDeps, cmd.Fn)
+// showProgress returns true if progress of the execution should be displayed instead of plain logs.
+func showProgress(flags *pflag.FlagSet, commands map[string]types.Command, paths []string) bool {
+	if !lo.Must(flags.GetBool("progress")) || lo.Must(flags.GetBool("verbose")) ||
+		lo.Must(flags.GetString("json-events")) == "-" || !isTerminal(os.Stdout) {
+		return false
 	}
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				if err, ok := r.(error); ok && errors.Is(err, errReturn) {
-					return
-				}
-				panic(r)
-			}
-		}()
-		depsFunc(initDeps...)
-	}()
-	if len(errChan) > 0 {
-		return <-errChan
+	for _, path := range paths {
+		if _, cmd, _ := lookupCommand(commands, path); cmd.Interactive {
+			return false
+		}
 	}
-	return nil
+	return true
 }
 
 func isAutocomplete() bool {
-	_, ok := autocompletePrefix()
+	_, ok := autocompleteArgs()
 	return ok
 }
 
-func listCommands(commands map[string]types.Command) {
-	paths := paths(commands)
+func printPlan(w io.Writer, plan []planStep) {
 	var maxLen int
-	for _, path := range paths {
-		if len(path) > maxLen {
-			maxLen = len(path)
+	for _, step := range plan {
+		if len(step.Path) > maxLen {
+			maxLen = len(step.Path)
 		}
 	}
-	fmt.Println("\n Available commands:")
-	fmt.Println()
-	for _, path := range paths {
-		fmt.Printf(fmt.Sprintf(`   %%-%ds`, maxLen)+"  %s\n", path, commands[path].Description)
+	numLen := len(strconv.Itoa(len(plan)))
+	fmt.Fprintln(w, "\n Execution plan:")
+	fmt.Fprintln(w)
+	for i, step := range plan {
+		fmt.Fprintf(w, fmt.Sprintf(`   %%%dd. %%-%ds`, numLen, maxLen)+"  %s\n", i+1, step.Path, step.Description)
 	}
-	fmt.Println("")
+	fmt.Fprintln(w, "")
 }
 
-func autocompletePrefix() (string, bool) {
-	exeName := os.Args[0]
+// autocompleteArgs returns arguments typed before the cursor, the last one is the one being completed.
+func autocompleteArgs() ([]string, bool) {
 	cLine := os.Getenv("COMP_LINE")
 	cPoint := os.Getenv("COMP_POINT")
 
 	if cLine == "" || cPoint == "" {
-		return "", false
+		return nil, false
 	}
 
 	cPointInt, err := strconv.ParseInt(cPoint, 10, 64)
@@ -201,37 +276,43 @@ func autocompletePrefix() (string, bool) {
 		panic(err)
 	}
 
-	prefix := strings.TrimLeft(cLine[:cPointInt], exeName)
-	lastSpace := strings.LastIndex(prefix, " ") + 1
-	return prefix[lastSpace:], true
+	words := strings.Split(cLine[:cPointInt], " ")
+	args := []string{}
+	for i, word := range words[1:] {
+		if word != "" || i == len(words)-2 {
+			args = append(args, word)
+		}
+	}
+	return args, true
 }
 
-func autocompleteDo(commands map[string]types.Command) {
-	prefix, _ := autocompletePrefix()
-	choices := choicesForPrefix(paths(commands), prefix)
+func autocompleteDo(ctx context.Context, flags *pflag.FlagSet, commands map[string]types.Command) {
+	args, _ := autocompleteArgs()
+	current := args[len(args)-1]
+
+	// Bash treats "=" as a word break, so only the part after it is replaced.
+	valueStart := strings.LastIndex(current, "=") + 1
+	values := []string{}
+	for _, c := range completions(ctx, flags, commands, args) {
+		values = append(values, c.Value[valueStart:])
+	}
+
 	switch os.Getenv("COMP_TYPE") {
 	case "9":
-		startPos := strings.LastIndex(prefix, "/") + 1
-		prefix = prefix[:startPos]
-		if len(choices) == 1 {
-			for choice, children := range choices {
-				if children {
-					choice += "/"
-				} else {
-					choice += " "
-				}
-				fmt.Println(prefix + choice)
+		if len(values) == 1 {
+			value := values[0]
+			if !strings.HasSuffix(value, "/") && !strings.HasSuffix(value, "=") {
+				value += " "
 			}
-		} else if chPrefix := longestPrefix(choices); chPrefix != "" {
-			fmt.Println(prefix + chPrefix)
+			fmt.Println(value)
+		} else if prefix := longestPrefix(values); prefix != "" {
+			fmt.Println(prefix)
 		}
 	case "63":
-		if len(choices) > 1 {
-			for choice, children := range choices {
-				if children {
-					choice += "/"
-				}
-				fmt.Println(choice)
+		if len(values) > 1 {
+			dir := current[:strings.LastIndex(current, "/")+1]
+			for _, value := range values {
+				fmt.Println(strings.TrimPrefix(value, dir))
 			}
 		}
 	}
@@ -246,6 +327,30 @@ func paths(commands map[string]types.Command) []string {
 	return paths
 }
 
+// visiblePaths returns sorted paths of commands which are not hidden.
+func visiblePaths(commands map[string]types.Command) []string {
+	visible := []string{}
+	for _, path := range paths(commands) {
+		if !commands[path].Hidden {
+			visible = append(visible, path)
+		}
+	}
+	return visible
+}
+
+// lookupCommand returns the command registered under the path or having it as an alias.
+func lookupCommand(commands map[string]types.Command, path string) (string, types.Command, bool) {
+	if cmd, exists := commands[path]; exists {
+		return path, cmd, true
+	}
+	for p, cmd := range commands {
+		if lo.Contains(cmd.Aliases, path) {
+			return p, cmd, true
+		}
+	}
+	return "", types.Command{}, false
+}
+
 func choicesForPrefix(paths []string, prefix string) map[string]bool {
 	startPos := strings.LastIndex(prefix, "/") + 1
 	choices := map[string]bool{}
@@ -266,26 +371,15 @@ func choicesForPrefix(paths []string, prefix string) map[string]bool {
 	return choices
 }
 
-func longestPrefix(choices map[string]bool) string {
-	if len(choices) == 0 {
+func longestPrefix(values []string) string {
+	if len(values) == 0 {
 		return ""
 	}
-	prefix := ""
-	for i := 0; true; i++ {
-		var ch uint8
-		for choice := range choices {
-			if i >= len(choice) {
-				return prefix
-			}
-			if ch == 0 {
-				ch = choice[i]
-				continue
-			}
-			if choice[i] != ch {
-				return prefix
-			}
+	prefix := values[0]
+	for _, value := range values[1:] {
+		for !strings.HasPrefix(value, prefix) {
+			prefix = prefix[:len(prefix)-1]
 		}
-		prefix += string(ch)
 	}
 	return prefix
 }
@@ -297,21 +391,54 @@ func changeWorkingDir() {
 func newCommandRegistry() commandRegistry {
 	return commandRegistry{
 		commands: map[string]types.Command{},
+		aliases:  map[string]string{},
 	}
 }
 
 type commandRegistry struct {
 	commands map[string]types.Command
+	aliases  map[string]string
 }
 
 func (cr commandRegistry) RegisterCommands(commands []map[string]types.Command) error {
 	for _, commandSet := range commands {
-		for path := range commandSet {
-			if _, exists := cr.commands[path]; exists {
-				return errors.Errorf("command %s has already been registered", path)
+		aliases := map[string]string{}
+		for path, cmd := range commandSet {
+			if err := cr.validatePath(path); err != nil {
+				return err
+			}
+			if _, err := newFlagSet(path, cmd); err != nil {
+				return err
+			}
+			for _, alias := range cmd.Aliases {
+				if err := cr.validatePath(alias); err != nil {
+					return err
+				}
+				if _, exists := commandSet[alias]; exists {
+					return errors.Errorf("command %s has already been registered", alias)
+				}
+				if _, exists := aliases[alias]; exists {
+					return errors.Errorf("command %s has already been registered", alias)
+				}
+				aliases[alias] = path
 			}
 		}
 		maps.Copy(cr.commands, commandSet)
+		maps.Copy(cr.aliases, aliases)
+	}
+	return nil
+}
+
+// validatePath checks that the path of the command or its alias is neither reserved nor taken.
+func (cr commandRegistry) validatePath(path string) error {
+	if path == completionCommand || path == completeCommand || path == helpCommand {
+		return errors.Errorf("command %s is reserved", path)
+	}
+	if _, exists := cr.commands[path]; exists {
+		return errors.Errorf("command %s has already been registered", path)
+	}
+	if _, exists := cr.aliases[path]; exists {
+		return errors.Errorf("command %s has already been registered", path)
 	}
 	return nil
 }
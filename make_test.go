@@ -2,37 +2,62 @@ package build
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 
+	"github.com/outofforest/build/v2/pkg/tools"
 	"github.com/outofforest/build/v2/pkg/types"
+	"github.com/outofforest/logger"
 )
 
-var r = map[int]string{}
+var r = &recorder{}
+
+type recorder struct {
+	mu      sync.Mutex
+	records []string
+}
+
+func (r *recorder) Record(record string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.records = append(r.records, record)
+}
+
+func (r *recorder) Records() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string{}, r.records...)
+}
 
 func cmdA(_ context.Context, deps types.DepsFunc) error {
 	deps(cmdAA, cmdAB)
-	r[len(r)] = "a"
+	r.Record("a")
 	return nil
 }
 
 func cmdAA(_ context.Context, deps types.DepsFunc) error {
 	deps(cmdAC)
-	r[len(r)] = "aa"
+	r.Record("aa")
 	return nil
 }
 
 func cmdAB(_ context.Context, deps types.DepsFunc) error {
 	deps(cmdAC)
-	r[len(r)] = "ab"
+	r.Record("ab")
 	return nil
 }
 
 func cmdAC(_ context.Context, deps types.DepsFunc) error {
-	r[len(r)] = "ac"
+	r.Record("ac")
 	return nil
 }
 
@@ -59,10 +84,45 @@ func cmdF(ctx context.Context, deps types.DepsFunc) error {
 	return ctx.Err()
 }
 
-var tCtx = context.Background()
+func cmdG(_ context.Context, deps types.DepsFunc) error {
+	deps(cmdGA, cmdGB)
+	return nil
+}
+
+func cmdGA(_ context.Context, deps types.DepsFunc) error {
+	deps(cmdGB)
+	return nil
+}
+
+func cmdGB(_ context.Context, deps types.DepsFunc) error {
+	deps(cmdGA)
+	return nil
+}
+
+func cmdH(_ context.Context, deps types.DepsFunc) error {
+	deps.Sequential(cmdHA, cmdHB)
+	r.Record("h")
+	return nil
+}
+
+func cmdHA(_ context.Context, deps types.DepsFunc) error {
+	r.Record("ha")
+	return nil
+}
+
+func cmdHB(_ context.Context, deps types.DepsFunc) error {
+	r.Record("hb")
+	return nil
+}
+
+var tCtx = logger.WithLogger(context.Background(), zap.NewNop())
+
+func setup(ctx context.Context) (func(paths []string) error, *recorder) {
+	return setupWithConfig(ctx, executorConfig{Jobs: 4})
+}
 
-func setup(ctx context.Context) (func(paths []string) error, map[int]string) {
-	r = map[int]string{}
+func setupWithConfig(ctx context.Context, config executorConfig) (func(paths []string) error, *recorder) {
+	r = &recorder{}
 	return func(paths []string) error {
 		return execute(ctx, map[string]types.Command{
 			"a":    {Fn: cmdA},
@@ -73,7 +133,9 @@ func setup(ctx context.Context) (func(paths []string) error, map[int]string) {
 			"d":    {Fn: cmdD},
 			"e":    {Fn: cmdE},
 			"f":    {Fn: cmdF},
-		}, paths)
+			"g":    {Fn: cmdG},
+			"h":    {Fn: cmdH},
+		}, paths, config)
 	}, r
 }
 
@@ -81,50 +143,50 @@ func TestRootCommand(t *testing.T) {
 	exe, r := setup(tCtx)
 	require.NoError(t, exe([]string{"a"}))
 
-	assert.Len(t, r, 4)
-	assert.Equal(t, "ac", r[0])
-	assert.Equal(t, "aa", r[1])
-	assert.Equal(t, "ab", r[2])
-	assert.Equal(t, "a", r[3])
+	records := r.Records()
+	require.Len(t, records, 4)
+	assert.Equal(t, "ac", records[0])
+	assert.ElementsMatch(t, []string{"aa", "ab"}, records[1:3])
+	assert.Equal(t, "a", records[3])
 }
 
 func TestChildCommand(t *testing.T) {
 	exe, r := setup(tCtx)
 	require.NoError(t, exe([]string{"a/aa"}))
 
-	assert.Len(t, r, 2)
-	assert.Equal(t, "ac", r[0])
-	assert.Equal(t, "aa", r[1])
+	assert.Equal(t, []string{"ac", "aa"}, r.Records())
 }
 
 func TestTwoCommands(t *testing.T) {
 	exe, r := setup(tCtx)
 	require.NoError(t, exe([]string{"a/aa", "a/ab"}))
 
-	assert.Len(t, r, 3)
-	assert.Equal(t, "ac", r[0])
-	assert.Equal(t, "aa", r[1])
-	assert.Equal(t, "ab", r[2])
+	assert.Equal(t, []string{"ac", "aa", "ab"}, r.Records())
 }
 
 func TestCommandWithSlash(t *testing.T) {
 	exe, r := setup(tCtx)
 	require.NoError(t, exe([]string{"a/aa/"}))
 
-	assert.Len(t, r, 2)
-	assert.Equal(t, "ac", r[0])
-	assert.Equal(t, "aa", r[1])
+	assert.Equal(t, []string{"ac", "aa"}, r.Records())
 }
 
 func TestCommandsAreExecutedOnce(t *testing.T) {
 	exe, r := setup(tCtx)
 	require.NoError(t, exe([]string{"a", "a"}))
 
-	assert.Len(t, r, 4)
-	assert.Equal(t, "ac", r[0])
-	assert.Equal(t, "aa", r[1])
-	assert.Equal(t, "ab", r[2])
-	assert.Equal(t, "a", r[3])
+	records := r.Records()
+	require.Len(t, records, 4)
+	assert.Equal(t, "ac", records[0])
+	assert.ElementsMatch(t, []string{"aa", "ab"}, records[1:3])
+	assert.Equal(t, "a", records[3])
+}
+
+func TestSequentialDependencies(t *testing.T) {
+	exe, r := setup(tCtx)
+	require.NoError(t, exe([]string{"h"}))
+
+	assert.Equal(t, []string{"ha", "hb", "h"}, r.Records())
 }
 
 func TestCommandReturnsError(t *testing.T) {
@@ -139,7 +201,41 @@ func TestCommandPanics(t *testing.T) {
 
 func TestErrorOnCyclicDependencies(t *testing.T) {
 	exe, _ := setup(tCtx)
-	require.Error(t, exe([]string{"c"}))
+	err := exe([]string{"c"})
+
+	var cycleErr CycleError
+	require.ErrorAs(t, err, &cycleErr)
+	assert.Equal(t, []string{"c", "d", "c"}, cycleErr.Path)
+}
+
+func TestErrorOnCyclicDependenciesInParallel(t *testing.T) {
+	exe, _ := setup(tCtx)
+	err := exe([]string{"g"})
+
+	var cycleErr CycleError
+	require.ErrorAs(t, err, &cycleErr)
+	require.Len(t, cycleErr.Path, 3)
+	assert.Contains(t, cycleErr.Path[0], "cmdG")
+	assert.Equal(t, cycleErr.Path[0], cycleErr.Path[2])
+}
+
+func deepCmd(level int) types.CommandFunc {
+	return func(_ context.Context, deps types.DepsFunc) error {
+		deps(deepCmd(level + 1))
+		return nil
+	}
+}
+
+func TestErrorOnStackOverflow(t *testing.T) {
+	err := execute(tCtx, map[string]types.Command{
+		"deep": {Fn: deepCmd(0)},
+	}, []string{"deep"}, executorConfig{Jobs: 4})
+
+	var stackErr StackOverflowError
+	require.ErrorAs(t, err, &stackErr)
+	require.Len(t, stackErr.Stack, maxStack+1)
+	assert.Equal(t, "deep", stackErr.Stack[0])
+	assert.Contains(t, stackErr.Stack[1], "deepCmd")
 }
 
 func TestRootCommandDoesNotExist(t *testing.T) {
@@ -159,3 +255,348 @@ func TestCommandStopsOnCanceledContext(t *testing.T) {
 	err := exe([]string{"f"})
 	assert.Equal(t, context.Canceled, err)
 }
+
+func TestDependenciesAreExecutedInParallel(t *testing.T) {
+	started := make(chan struct{})
+	cmd := func(ctx context.Context, deps types.DepsFunc) error {
+		select {
+		case started <- struct{}{}:
+			return nil
+		case <-started:
+			return nil
+		case <-time.After(5 * time.Second):
+			return errors.New("dependencies are not executed in parallel")
+		}
+	}
+	cmd1 := func(ctx context.Context, deps types.DepsFunc) error {
+		return cmd(ctx, deps)
+	}
+	cmd2 := func(ctx context.Context, deps types.DepsFunc) error {
+		return cmd(ctx, deps)
+	}
+
+	require.NoError(t, execute(tCtx, map[string]types.Command{
+		"a": {Fn: func(ctx context.Context, deps types.DepsFunc) error {
+			deps(cmd1, cmd2)
+			return nil
+		}},
+	}, []string{"a"}, executorConfig{Jobs: 2}))
+}
+
+func TestNumberOfJobsIsLimited(t *testing.T) {
+	var running, maxRunning atomic.Int64
+	cmd := func(ctx context.Context, deps types.DepsFunc) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}
+	cmds := make([]types.CommandFunc, 0, 10)
+	for range 10 {
+		cmds = append(cmds, func(ctx context.Context, deps types.DepsFunc) error {
+			return cmd(ctx, deps)
+		})
+	}
+
+	require.NoError(t, execute(tCtx, map[string]types.Command{
+		"a": {Fn: func(ctx context.Context, deps types.DepsFunc) error {
+			deps(cmds...)
+			return nil
+		}},
+	}, []string{"a"}, executorConfig{Jobs: 3}))
+	assert.EqualValues(t, 3, maxRunning.Load())
+}
+
+func TestDryRun(t *testing.T) {
+	var dryRun, toolsDryRun bool
+	e := newExecutor(map[string]types.Command{
+		"a":    {Fn: cmdA, Description: "Command A"},
+		"a/aa": {Fn: cmdAA, Description: "Command AA"},
+		"x": {Fn: func(ctx context.Context, deps types.DepsFunc) error {
+			dryRun = IsDryRun(ctx)
+			toolsDryRun = tools.IsDryRun(ctx)
+			return nil
+		}},
+	}, executorConfig{Jobs: 4, DryRun: true})
+	r = &recorder{}
+	require.NoError(t, e.Execute(tCtx, []string{"a", "x"}))
+
+	assert.True(t, dryRun)
+	assert.True(t, toolsDryRun)
+	assert.Equal(t, []planStep{
+		{Path: "github.com/outofforest/build/v2.cmdAC"},
+		{Path: "a/aa", Description: "Command AA"},
+		{Path: "github.com/outofforest/build/v2.cmdAB"},
+		{Path: "a", Description: "Command A"},
+		{Path: "x"},
+	}, e.Plan())
+}
+
+func TestTimings(t *testing.T) {
+	sleep := func(d time.Duration) types.CommandFunc {
+		return func(_ context.Context, _ types.DepsFunc) error {
+			time.Sleep(d)
+			return nil
+		}
+	}
+	cmdShort := sleep(10 * time.Millisecond)
+	cmdLong := sleep(50 * time.Millisecond)
+
+	e := newExecutor(map[string]types.Command{
+		"a": {Fn: func(_ context.Context, deps types.DepsFunc) error {
+			deps(cmdShort, cmdLong)
+			return nil
+		}},
+		"short": {Fn: cmdShort},
+		"long":  {Fn: cmdLong},
+	}, executorConfig{Jobs: 4})
+	require.NoError(t, e.Execute(tCtx, []string{"a"}))
+
+	timings := e.Timings()
+	require.Len(t, timings, 3)
+	assert.Equal(t, "long", timings[0].Path)
+	assert.GreaterOrEqual(t, timings[0].Exclusive, 50*time.Millisecond)
+	assert.Equal(t, "short", timings[1].Path)
+	assert.Equal(t, "a", timings[2].Path)
+	assert.Less(t, timings[2].Exclusive, 10*time.Millisecond)
+	assert.GreaterOrEqual(t, timings[2].Inclusive, 50*time.Millisecond)
+
+	criticalPath := e.CriticalPath()
+	require.Len(t, criticalPath, 2)
+	assert.Equal(t, "a", criticalPath[0].Path)
+	assert.Equal(t, "long", criticalPath[1].Path)
+}
+
+func TestShowTimings(t *testing.T) {
+	commands := map[string]types.Command{
+		"a":     {Fn: cmdA},
+		"shell": {Fn: cmdB, Interactive: true},
+		"enter": Commands["enter"],
+	}
+	timings := []commandTiming{{Path: "a"}, {Path: "a/aa"}}
+
+	assert.True(t, showTimings(commands, []string{"a"}, timings))
+	assert.False(t, showTimings(commands, []string{"a"}, timings[:1]))
+	assert.False(t, showTimings(commands, []string{"a", "shell"}, timings))
+	assert.False(t, showTimings(commands, []string{"enter"}, timings))
+}
+
+func TestTrace(t *testing.T) {
+	e := newExecutor(map[string]types.Command{
+		"a":    {Fn: cmdA},
+		"a/aa": {Fn: cmdAA},
+		"a/ab": {Fn: cmdAB},
+	}, executorConfig{Jobs: 1})
+	r = &recorder{}
+	require.NoError(t, e.Execute(tCtx, []string{"a"}))
+
+	spans := map[string]traceEvent{}
+	var lanes int
+	for _, event := range e.Trace() {
+		switch event.Phase {
+		case "X":
+			assert.NotContains(t, spans, event.Name)
+			spans[event.Name] = event
+		case "i":
+			lanes++
+		}
+	}
+	require.Len(t, spans, 4)
+
+	// cmdAC is requested by both a/aa and a/ab, so it is nested under the one which requested it first.
+	assert.Contains(t, []any{"a/aa", "a/ab"}, spans["github.com/outofforest/build/v2.cmdAC"].Args["requestedBy"])
+	assert.Equal(t, "a", spans["a/aa"].Args["requestedBy"])
+	assert.Equal(t, "a", spans["a/ab"].Args["requestedBy"])
+	assert.NotContains(t, spans["a"].Args, "requestedBy")
+
+	for _, child := range []string{"github.com/outofforest/build/v2.cmdAC", "a/aa", "a/ab"} {
+		parent := spans[child].Args["requestedBy"].(string)
+		assert.GreaterOrEqual(t, spans[child].Timestamp, spans[parent].Timestamp)
+		assert.LessOrEqual(t, spans[child].Timestamp+spans[child].Duration,
+			spans[parent].Timestamp+spans[parent].Duration)
+	}
+
+	// Sibling spans may overlap, so they can't always share the track of their parent. Spans overlapping
+	// on the same track must be nested and belong to the same chain of requests.
+	assert.Contains(t, []int{spans["a/aa"].TID, spans["a/ab"].TID}, spans["a"].TID)
+	for _, span1 := range spans {
+		for _, span2 := range spans {
+			if span1.Name == span2.Name || span1.TID != span2.TID || span1.Timestamp > span2.Timestamp ||
+				span2.Timestamp >= span1.Timestamp+span1.Duration {
+				continue
+			}
+			assert.LessOrEqual(t, span2.Timestamp+span2.Duration, span1.Timestamp+span1.Duration)
+			assert.True(t, requestedBy(spans, span2.Name, span1.Name))
+		}
+	}
+
+	// Commands waiting for their dependencies release the lane and take it again once they are completed.
+	assert.Equal(t, 7, lanes)
+}
+
+// requestedBy returns true if the command of the span was requested by the other command, directly or indirectly.
+func requestedBy(spans map[string]traceEvent, name, other string) bool {
+	for parent, ok := spans[name].Args["requestedBy"].(string); ok; parent, ok = spans[parent].Args["requestedBy"].(string) {
+		if parent == other {
+			return true
+		}
+	}
+	return false
+}
+
+func TestKeepGoing(t *testing.T) {
+	errFailed := errors.New("failed")
+	cmdFailing := func(_ context.Context, _ types.DepsFunc) error {
+		return errFailed
+	}
+	cmdOK := func(_ context.Context, _ types.DepsFunc) error {
+		r.Record("ok")
+		return nil
+	}
+
+	r = &recorder{}
+	err := execute(tCtx, map[string]types.Command{
+		"a": {Fn: func(_ context.Context, deps types.DepsFunc) error {
+			deps(cmdFailing, cmdOK)
+			r.Record("a")
+			return nil
+		}},
+		"b":       {Fn: cmdB},
+		"c":       {Fn: cmdC},
+		"d":       {Fn: cmdD},
+		"failing": {Fn: cmdFailing},
+		"ok":      {Fn: cmdOK},
+		"h":       {Fn: cmdH},
+	}, []string{"a", "b", "c", "h"}, executorConfig{Jobs: 4, KeepGoing: true})
+
+	assert.Equal(t, []string{"ok", "ha", "hb", "h"}, r.Records())
+
+	var cmdErr CommandError
+	require.ErrorAs(t, err, &cmdErr)
+	assert.Equal(t, "failing", cmdErr.Path)
+	require.ErrorIs(t, err, errFailed)
+
+	errs := err.(interface{ Unwrap() []error }).Unwrap()
+	require.Len(t, errs, 3)
+	paths := []string{}
+	for _, err := range errs {
+		var cmdErr CommandError
+		require.ErrorAs(t, err, &cmdErr)
+		paths = append(paths, cmdErr.Path)
+	}
+	assert.Equal(t, []string{"failing", "b", "d"}, paths)
+}
+
+func TestCommandTimeout(t *testing.T) {
+	r = &recorder{}
+	err := execute(tCtx, map[string]types.Command{
+		"f": {Fn: cmdF, Timeout: 10 * time.Millisecond},
+	}, []string{"f"}, executorConfig{Jobs: 4})
+
+	var timeoutErr TimeoutError
+	require.ErrorAs(t, err, &timeoutErr)
+	assert.Equal(t, "f", timeoutErr.Path)
+	assert.Equal(t, 10*time.Millisecond, timeoutErr.Timeout)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.EqualError(t, err, "build: command f timed out after 10ms: context deadline exceeded")
+}
+
+func TestDependencyTimeout(t *testing.T) {
+	var count atomic.Int32
+	cmdSlow := func(ctx context.Context, _ types.DepsFunc) error {
+		count.Add(1)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	r = &recorder{}
+	err := execute(tCtx, map[string]types.Command{
+		"a": {Fn: func(_ context.Context, deps types.DepsFunc) error {
+			deps(WithTimeout(cmdSlow, 10*time.Millisecond), WithTimeout(cmdSlow, 10*time.Millisecond))
+			return nil
+		}},
+	}, []string{"a"}, executorConfig{Jobs: 4})
+
+	var timeoutErr TimeoutError
+	require.ErrorAs(t, err, &timeoutErr)
+	assert.Equal(t, 10*time.Millisecond, timeoutErr.Timeout)
+	assert.EqualValues(t, 1, count.Load())
+}
+
+func TestTimeoutDoesNotAffectDependencies(t *testing.T) {
+	cmdDep := func(ctx context.Context, _ types.DepsFunc) error {
+		time.Sleep(50 * time.Millisecond)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		r.Record("dep")
+		return nil
+	}
+
+	r = &recorder{}
+	err := execute(tCtx, map[string]types.Command{
+		"a": {Fn: func(_ context.Context, deps types.DepsFunc) error {
+			deps(cmdDep)
+			return nil
+		}, Timeout: 10 * time.Millisecond},
+		"b": {Fn: func(_ context.Context, deps types.DepsFunc) error {
+			deps(cmdDep)
+			r.Record("b")
+			return nil
+		}},
+	}, []string{"a", "b"}, executorConfig{Jobs: 4, KeepGoing: true})
+
+	var timeoutErr TimeoutError
+	require.ErrorAs(t, err, &timeoutErr)
+	assert.Equal(t, "a", timeoutErr.Path)
+	assert.Equal(t, []string{"dep", "b"}, r.Records())
+}
+
+func cmdBuildFor(_ context.Context, _ types.DepsFunc, platform string) error {
+	r.Record(platform)
+	return nil
+}
+
+func TestParameterizedDependencies(t *testing.T) {
+	r = &recorder{}
+	e := newExecutor(map[string]types.Command{
+		"a": {Fn: func(_ context.Context, deps types.DepsFunc) error {
+			deps.Sequential(Dep(cmdBuildFor, "linux/amd64"), Dep(cmdBuildFor, "darwin/arm64"))
+			deps(Dep(cmdBuildFor, "linux/amd64"), Dep(cmdBuildFor, "darwin/arm64"))
+			return nil
+		}},
+	}, executorConfig{Jobs: 4, DryRun: true})
+	require.NoError(t, e.Execute(tCtx, []string{"a"}))
+
+	assert.Equal(t, []string{"linux/amd64", "darwin/arm64"}, r.Records())
+	assert.Equal(t, []planStep{
+		{Path: "github.com/outofforest/build/v2.cmdBuildFor(linux/amd64)"},
+		{Path: "github.com/outofforest/build/v2.cmdBuildFor(darwin/arm64)"},
+		{Path: "a"},
+	}, e.Plan())
+}
+
+func TestParameterizedDependencyWithTimeout(t *testing.T) {
+	cmdSlow := func(ctx context.Context, _ types.DepsFunc, key int) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	err := execute(tCtx, map[string]types.Command{
+		"a": {Fn: func(_ context.Context, deps types.DepsFunc) error {
+			deps(WithTimeout(Dep(cmdSlow, 1), 10*time.Millisecond))
+			return nil
+		}},
+	}, []string{"a"}, executorConfig{Jobs: 4})
+
+	var timeoutErr TimeoutError
+	require.ErrorAs(t, err, &timeoutErr)
+	assert.Equal(t, "github.com/outofforest/build/v2.TestParameterizedDependencyWithTimeout.func1(1)", timeoutErr.Path)
+}
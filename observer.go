@@ -0,0 +1,91 @@
+package build
+
+import (
+	"time"
+)
+
+const (
+	skipReasonUpToDate  = "up to date"
+	skipReasonRestored  = "outputs restored from cache"
+	skipReasonDepFailed = "dependency failed"
+)
+
+// CommandEvent describes the change of the command state.
+type CommandEvent struct {
+	// Path is the path of the command, or the name of the function if command is not registered.
+	Path string
+
+	// Time is the time of the event.
+	Time time.Time
+
+	// Duration is the time spent on executing the command, excluding its dependencies.
+	Duration time.Duration
+
+	// DurationWithDeps is the time spent on executing the command, including its dependencies.
+	DurationWithDeps time.Duration
+
+	// Reason explains why command has been skipped.
+	Reason string
+
+	// Output is the output of the command, if it is captured.
+	Output string
+
+	// Err is the error returned by the command.
+	Err error
+}
+
+// BuildEvent describes the completed build.
+type BuildEvent struct {
+	// Time is the time of the event.
+	Time time.Time
+
+	// Duration is the time spent on the entire build.
+	Duration time.Duration
+
+	// Err is the error returned by the build.
+	Err error
+}
+
+// Observer is notified about the progress of the execution. Methods are called concurrently
+// by all the running commands, so implementation must be safe for concurrent use.
+type Observer interface {
+	// CommandStarted is called when command starts.
+	CommandStarted(event CommandEvent)
+
+	// CommandSkipped is called when command is not executed because it is up to date,
+	// its outputs are restored from cache or its dependency failed.
+	CommandSkipped(event CommandEvent)
+
+	// CommandSucceeded is called when command completes successfully.
+	CommandSucceeded(event CommandEvent)
+
+	// CommandFailed is called when command fails.
+	CommandFailed(event CommandEvent)
+
+	// BuildFinished is called once all the commands complete.
+	BuildFinished(event BuildEvent)
+}
+
+// NopObserver implements Observer ignoring all the events. Embed it to implement only the required methods.
+type NopObserver struct{}
+
+// CommandStarted is called when command starts.
+func (NopObserver) CommandStarted(CommandEvent) {}
+
+// CommandSkipped is called when command is skipped.
+func (NopObserver) CommandSkipped(CommandEvent) {}
+
+// CommandSucceeded is called when command completes successfully.
+func (NopObserver) CommandSucceeded(CommandEvent) {}
+
+// CommandFailed is called when command fails.
+func (NopObserver) CommandFailed(CommandEvent) {}
+
+// BuildFinished is called once all the commands complete.
+func (NopObserver) BuildFinished(BuildEvent) {}
+
+func (e *executor) notify(fn func(o Observer)) {
+	for _, o := range e.config.Observers {
+		fn(o)
+	}
+}
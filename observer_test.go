@@ -0,0 +1,78 @@
+package build
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/outofforest/build/v2/pkg/types"
+)
+
+type recordingObserver struct {
+	NopObserver
+
+	mu     sync.Mutex
+	events []string
+	build  BuildEvent
+}
+
+func (o *recordingObserver) CommandStarted(event CommandEvent) {
+	o.record("started " + event.Path)
+}
+
+func (o *recordingObserver) CommandSkipped(event CommandEvent) {
+	o.record("skipped " + event.Path + ": " + event.Reason)
+}
+
+func (o *recordingObserver) CommandSucceeded(event CommandEvent) {
+	o.record("succeeded " + event.Path)
+}
+
+func (o *recordingObserver) CommandFailed(event CommandEvent) {
+	o.record("failed " + event.Path + ": " + event.Err.Error())
+}
+
+func (o *recordingObserver) BuildFinished(event BuildEvent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.build = event
+}
+
+func (o *recordingObserver) record(event string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.events = append(o.events, event)
+}
+
+func TestObserverIsNotified(t *testing.T) {
+	o := &recordingObserver{}
+	err := execute(tCtx, map[string]types.Command{
+		"h": {Fn: cmdH},
+		"b": {Fn: func(_ context.Context, deps types.DepsFunc) error {
+			deps(cmdB)
+			return nil
+		}},
+		"c": {Fn: cmdB},
+	}, []string{"h", "b"}, executorConfig{Jobs: 1, Observers: []Observer{o}})
+	require.EqualError(t, err, "error")
+
+	assert.Equal(t, []string{
+		"started h",
+		"started github.com/outofforest/build/v2.cmdHA",
+		"succeeded github.com/outofforest/build/v2.cmdHA",
+		"started github.com/outofforest/build/v2.cmdHB",
+		"succeeded github.com/outofforest/build/v2.cmdHB",
+		"succeeded h",
+		"started b",
+		"started c",
+		"failed c: error",
+		"skipped b: dependency failed",
+	}, o.events)
+	require.EqualError(t, o.build.Err, "error")
+	assert.Positive(t, o.build.Duration)
+}
@@ -0,0 +1,165 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/outofforest/libexec"
+)
+
+// Stdout returns the writer the command should use as its standard output.
+// Lines written to it are prefixed with the path of the command.
+func Stdout(ctx context.Context) io.Writer {
+	if out := getOutput(ctx); out != nil {
+		return out.stdout
+	}
+	return os.Stdout
+}
+
+// Stderr returns the writer the command should use as its standard error.
+// Lines written to it are prefixed with the path of the command.
+func Stderr(ctx context.Context) io.Writer {
+	if out := getOutput(ctx); out != nil {
+		return out.stderr
+	}
+	return os.Stderr
+}
+
+// rawStdout returns the writer receiving the standard output of commands, without prefixes.
+// It is used by built-in commands printing output which is processed by other tools.
+func rawStdout(ctx context.Context) io.Writer {
+	e := getExecutor(ctx)
+	return &lockedWriter{lock: &e.outputMu, w: e.config.Stdout}
+}
+
+// lockedWriter writes to the underlying writer, so output is not mixed with lines of other commands.
+type lockedWriter struct {
+	lock *sync.Mutex
+	w    io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.w.Write(p)
+}
+
+// Exec executes child processes using `libexec.Exec`. If their standard output or error is not set,
+// output of the command is used.
+func Exec(ctx context.Context, cmds ...*exec.Cmd) error {
+	for _, cmd := range cmds {
+		if cmd.Stdout == nil {
+			cmd.Stdout = Stdout(ctx)
+		}
+		if cmd.Stderr == nil {
+			cmd.Stderr = Stderr(ctx)
+		}
+	}
+	return libexec.Exec(ctx, cmds...)
+}
+
+func newCommandOutput(path string, lock *sync.Mutex, stdout, stderr io.Writer, buffered, captured bool) *commandOutput {
+	out := &commandOutput{
+		prefix:   []byte("[" + path + "] "),
+		lock:     lock,
+		buffered: buffered,
+	}
+	if buffered || captured {
+		out.buffer = &bytes.Buffer{}
+	}
+	out.stdout = &lineWriter{out: out, dst: stdout}
+	out.stderr = &lineWriter{out: out, dst: stderr}
+	return out
+}
+
+// commandOutput collects the output of the command.
+type commandOutput struct {
+	prefix []byte
+	// lock is shared by all the commands, so their lines are not mixed.
+	lock *sync.Mutex
+	// buffer keeps the lines if output is buffered or captured.
+	buffer *bytes.Buffer
+	// buffered is set if lines are stored in the buffer only.
+	buffered bool
+
+	stdout *lineWriter
+	stderr *lineWriter
+}
+
+// Flush writes incomplete lines.
+func (out *commandOutput) Flush() {
+	out.lock.Lock()
+	defer out.lock.Unlock()
+
+	out.stdout.flush()
+	out.stderr.flush()
+}
+
+// Captured returns the lines stored in the buffer.
+func (out *commandOutput) Captured() string {
+	if out.buffer == nil {
+		return ""
+	}
+
+	out.lock.Lock()
+	defer out.lock.Unlock()
+
+	return out.buffer.String()
+}
+
+// Dump writes the buffered lines to the writer.
+func (out *commandOutput) Dump(w io.Writer) {
+	if !out.buffered {
+		return
+	}
+
+	out.lock.Lock()
+	defer out.lock.Unlock()
+
+	_, _ = w.Write(out.buffer.Bytes())
+}
+
+func (out *commandOutput) writeLine(dst io.Writer, line []byte) {
+	line = append(append([]byte{}, out.prefix...), line...)
+	if out.buffer != nil {
+		out.buffer.Write(line)
+	}
+	if !out.buffered {
+		_, _ = dst.Write(line)
+	}
+}
+
+// lineWriter prefixes each line written to it with the path of the command.
+type lineWriter struct {
+	out     *commandOutput
+	dst     io.Writer
+	partial []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.out.lock.Lock()
+	defer w.out.lock.Unlock()
+
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.out.writeLine(w.dst, w.partial[:i+1])
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) flush() {
+	if len(w.partial) > 0 {
+		w.out.writeLine(w.dst, append(w.partial, '\n'))
+		w.partial = nil
+	}
+}
@@ -0,0 +1,82 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/outofforest/build/v2/pkg/types"
+)
+
+func TestOutputIsPrefixed(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	err := execute(tCtx, map[string]types.Command{
+		"a": {Fn: func(ctx context.Context, deps types.DepsFunc) error {
+			deps(Dep(func(ctx context.Context, _ types.DepsFunc, key string) error {
+				fmt.Fprint(Stdout(ctx), "dep")
+				return nil
+			}, "x"))
+			fmt.Fprint(Stdout(ctx), "line 1\nline")
+			fmt.Fprint(Stdout(ctx), " 2\nline 3")
+			fmt.Fprintln(Stderr(ctx), "error")
+			return nil
+		}},
+	}, []string{"a"}, executorConfig{Jobs: 4, Stdout: stdout, Stderr: stderr})
+	require.NoError(t, err)
+
+	assert.Equal(t, "[github.com/outofforest/build/v2.TestOutputIsPrefixed.func1.1(x)] dep\n"+
+		"[a] line 1\n[a] line 2\n[a] line 3\n", stdout.String())
+	assert.Equal(t, "[a] error\n", stderr.String())
+}
+
+func TestBufferedOutputIsPrintedOnFailure(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	err := execute(tCtx, map[string]types.Command{
+		"a": {Fn: func(ctx context.Context, deps types.DepsFunc) error {
+			fmt.Fprintln(Stdout(ctx), "a output")
+			deps(func(ctx context.Context, _ types.DepsFunc) error {
+				fmt.Fprintln(Stdout(ctx), "b output")
+				fmt.Fprintln(Stderr(ctx), "b error")
+				return errors.New("failed")
+			})
+			return nil
+		}},
+	}, []string{"a"}, executorConfig{Jobs: 4, Stdout: stdout, Stderr: stderr, BufferOutput: true})
+	require.Error(t, err)
+
+	assert.Empty(t, stdout.String())
+	assert.Equal(t, "[github.com/outofforest/build/v2.TestBufferedOutputIsPrintedOnFailure.func1.1] b output\n"+
+		"[github.com/outofforest/build/v2.TestBufferedOutputIsPrintedOnFailure.func1.1] b error\n", stderr.String())
+}
+
+func TestOutputOfChildProcess(t *testing.T) {
+	o := &recordingOutputObserver{}
+	stdout := &bytes.Buffer{}
+	err := execute(tCtx, map[string]types.Command{
+		"a": {Fn: func(ctx context.Context, _ types.DepsFunc) error {
+			return Exec(ctx, exec.Command("echo", "hello"))
+		}},
+	}, []string{"a"}, executorConfig{Jobs: 4, Stdout: stdout, CaptureOutput: true, Observers: []Observer{o}})
+	require.NoError(t, err)
+
+	assert.Equal(t, "[a] hello\n", stdout.String())
+	assert.Equal(t, "[a] hello\n", o.output)
+}
+
+type recordingOutputObserver struct {
+	NopObserver
+
+	output string
+}
+
+func (o *recordingOutputObserver) CommandSucceeded(event CommandEvent) {
+	o.output = event.Output
+}
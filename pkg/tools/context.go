@@ -6,9 +6,12 @@ type nameFiedType int
 
 type versionFieldType int
 
+type dryRunFieldType int
+
 const (
 	nameField    nameFiedType     = iota
 	versionField versionFieldType = iota
+	dryRunField  dryRunFieldType  = iota
 )
 
 // WithName creates context with name embedded.
@@ -30,3 +33,14 @@ func WithVersion(ctx context.Context, version string) context.Context {
 func GetVersion(ctx context.Context) string {
 	return ctx.Value(versionField).(string)
 }
+
+// WithDryRun creates context marking that commands are executed in dry-run mode.
+func WithDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunField, true)
+}
+
+// IsDryRun returns true if commands are executed in dry-run mode, meaning they should skip their side effects.
+func IsDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunField).(bool)
+	return dryRun
+}
@@ -1,13 +1,16 @@
 package docker
 
 import (
+	"bytes"
 	"context"
 	"os/exec"
+	"strings"
 
 	"github.com/pkg/errors"
 
 	"github.com/outofforest/build/v2/pkg/helpers"
 	"github.com/outofforest/build/v2/pkg/types"
+	"github.com/outofforest/libexec"
 )
 
 // Label used to tag docker resources created by localnet.
@@ -28,3 +31,14 @@ func EnsureDocker(_ context.Context, _ types.DepsFunc) error {
 func Cmd(args ...string) *exec.Cmd {
 	return helpers.ToolCmd("docker", args)
 }
+
+// Images returns images labelled with LabelKey. It may be used to complete values of flags.
+func Images(ctx context.Context) ([]string, error) {
+	buf := &bytes.Buffer{}
+	cmd := Cmd("images", "--filter", "label="+LabelKey+"="+LabelValue, "--format", "{{.Repository}}:{{.Tag}}")
+	cmd.Stdout = buf
+	if err := libexec.Exec(ctx, cmd); err != nil {
+		return nil, errors.Wrap(err, "docker command failed")
+	}
+	return strings.Fields(buf.String()), nil
+}
@@ -10,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -73,6 +74,15 @@ func Add(tools ...Tool) {
 	}
 }
 
+// List returns all the tools sorted by name.
+func List() []Tool {
+	tools := lo.Values(toolsMap)
+	sort.Slice(tools, func(i, j int) bool {
+		return tools[i].GetName() < tools[j].GetName()
+	})
+	return tools
+}
+
 // Source represents source where tool is fetched from.
 type Source struct {
 	URL   string
@@ -248,8 +258,11 @@ func (bt BinaryTool) install(ctx context.Context, platform Platform) (retErr err
 	return nil
 }
 
-// EnsureAll ensures all the tools.
+// EnsureAll ensures all the tools. Nothing is done in dry-run mode.
 func EnsureAll(ctx context.Context, _ types.DepsFunc) error {
+	if IsDryRun(ctx) {
+		return nil
+	}
 	for _, tool := range toolsMap {
 		isCompatible, err := tool.IsCompatible(PlatformLocal)
 		if err != nil {
@@ -265,17 +278,20 @@ func EnsureAll(ctx context.Context, _ types.DepsFunc) error {
 	return nil
 }
 
-// Ensure ensures tool exists for the platform.
+// Ensure ensures tool exists for the platform. Nothing is done in dry-run mode.
 func Ensure(ctx context.Context, toolName Name, platform Platform) error {
 	tool, err := Get(toolName)
-	if err != nil {
+	if err != nil || IsDryRun(ctx) {
 		return err
 	}
 	return tool.Ensure(ctx, platform)
 }
 
-// VerifyChecksums of all the tools.
+// VerifyChecksums of all the tools. Nothing is done in dry-run mode.
 func VerifyChecksums(ctx context.Context, _ types.DepsFunc) error {
+	if IsDryRun(ctx) {
+		return nil
+	}
 	allErrs := []error{}
 	for _, tool := range toolsMap {
 		errs, err := tool.Verify(ctx)
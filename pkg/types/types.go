@@ -1,6 +1,9 @@
 package types
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 // CommandFunc represents function executing command.
 type CommandFunc func(ctx context.Context, deps DepsFunc) error
@@ -9,7 +12,72 @@ type CommandFunc func(ctx context.Context, deps DepsFunc) error
 type Command struct {
 	Description string
 	Fn          CommandFunc
+
+	// LongDescription is displayed by `help` command.
+	LongDescription string
+
+	// Examples are the examples of usage displayed by `help` command.
+	Examples []string
+
+	// Tags are the labels displayed by `help` command.
+	Tags []string
+
+	// Aliases are the other paths the command may be executed by, e.g. the old ones after it was renamed.
+	Aliases []string
+
+	// Deprecated is the message printed when the command is executed, e.g. telling what to use instead.
+	// Empty message means the command is not deprecated.
+	Deprecated string
+
+	// Hidden is set if command is not listed and not autocompleted, e.g. internal helper one.
+	// It still may be executed.
+	Hidden bool
+
+	// Deps are the dependencies executed before the command. Incremental command must declare its dependencies
+	// here, because they have to complete before its inputs are checked, and dependencies requested by Fn
+	// are not executed if the command is skipped.
+	Deps []CommandFunc
+
+	// Inputs are the glob patterns of files the command depends on. If they are set, command is skipped
+	// if neither inputs nor outputs changed since its last successful execution. `**` matches any number
+	// of directories.
+	Inputs []string
+
+	// Outputs are the paths of files and directories produced by the command.
+	Outputs []string
+
+	// Timeout is the maximum time command may take, including the time spent on waiting for its dependencies.
+	// Zero means no timeout.
+	Timeout time.Duration
+
+	// Interactive is set if command interacts with the user using the terminal, so progress of the execution
+	// must not be displayed.
+	Interactive bool
+
+	// Flags are the flags accepted by the command, e.g. `projname deploy/db --env=staging`.
+	Flags []Flag
+}
+
+// Flag defines the flag accepted by the command.
+type Flag struct {
+	Name string
+	Help string
+
+	// Default is the value used if flag is not provided. Its type determines the type of the flag.
+	// Supported types are: string, bool, int, float64, time.Duration and []string.
+	Default any
+
+	// Complete returns values of the flag proposed by autocompletion.
+	Complete func(ctx context.Context) ([]string, error)
 }
 
 // DepsFunc represents function for executing dependencies.
+// Dependencies passed in a single call are executed concurrently.
 type DepsFunc func(deps ...CommandFunc)
+
+// Sequential executes dependencies one by one, in the specified order.
+func (d DepsFunc) Sequential(deps ...CommandFunc) {
+	for _, dep := range deps {
+		d(dep)
+	}
+}
@@ -0,0 +1,196 @@
+package build
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	progressInterval   = 100 * time.Millisecond
+	maxProgressRunning = 10
+
+	colorReset  = "\x1b[0m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+)
+
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// isTerminal returns true if file is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func newProgressUI(w io.Writer) *progressUI {
+	return &progressUI{
+		w:       w,
+		running: map[string]time.Time{},
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// progressUI displays spinners next to running commands below the list of completed ones.
+// Output of commands and warnings are printed above the spinners.
+type progressUI struct {
+	w       io.Writer
+	stop    chan struct{}
+	stopped chan struct{}
+
+	mu      sync.Mutex
+	running map[string]time.Time
+	frame   int
+	lines   int
+}
+
+// Start starts refreshing the spinners.
+func (ui *progressUI) Start() {
+	go func() {
+		defer close(ui.stopped)
+
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ui.stop:
+				return
+			case <-ticker.C:
+				ui.mu.Lock()
+				ui.frame = (ui.frame + 1) % len(spinnerFrames)
+				ui.clear()
+				ui.draw()
+				ui.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// Stop stops refreshing the spinners and removes them.
+func (ui *progressUI) Stop() {
+	close(ui.stop)
+	<-ui.stopped
+
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+
+	ui.clear()
+}
+
+// CommandStarted is called when command starts.
+func (ui *progressUI) CommandStarted(event CommandEvent) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+
+	ui.running[event.Path] = event.Time
+	ui.clear()
+	ui.draw()
+}
+
+// CommandSkipped is called when command is skipped.
+func (ui *progressUI) CommandSkipped(event CommandEvent) {
+	ui.finish(event.Path, fmt.Sprintf("%s-%s %s %s(%s)%s\n", colorYellow, colorReset, event.Path,
+		colorYellow, event.Reason, colorReset))
+}
+
+// CommandSucceeded is called when command completes successfully.
+func (ui *progressUI) CommandSucceeded(event CommandEvent) {
+	ui.finish(event.Path, fmt.Sprintf("%s✓%s %s %s\n", colorGreen, colorReset, event.Path,
+		roundDuration(event.Duration)))
+}
+
+// CommandFailed is called when command fails.
+func (ui *progressUI) CommandFailed(event CommandEvent) {
+	ui.finish(event.Path, fmt.Sprintf("%s✗ %s %s: %s%s\n", colorRed, event.Path,
+		roundDuration(event.Duration), event.Err, colorReset))
+}
+
+// BuildFinished is called once all the commands complete.
+func (ui *progressUI) BuildFinished(BuildEvent) {}
+
+// LogWritten is called when log entry is written. Only warnings and errors are printed,
+// because the progress of commands is displayed by spinners.
+func (ui *progressUI) LogWritten(entry zapcore.Entry, command string, fields map[string]any) {
+	if entry.Level < zapcore.WarnLevel {
+		return
+	}
+
+	line := &strings.Builder{}
+	if command != "" {
+		fmt.Fprintf(line, "[%s] ", command)
+	}
+	fmt.Fprintf(line, "%s%s%s %s", colorYellow, strings.ToUpper(entry.Level.String()), colorReset, entry.Message)
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(line, " %s=%v", key, fields[key])
+	}
+	line.WriteString("\n")
+
+	_, _ = ui.Write([]byte(line.String()))
+}
+
+// Write prints the output above the spinners.
+func (ui *progressUI) Write(p []byte) (int, error) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+
+	ui.clear()
+	n, err := ui.w.Write(p)
+	ui.draw()
+	return n, err
+}
+
+func (ui *progressUI) finish(path, line string) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+
+	delete(ui.running, path)
+	ui.clear()
+	_, _ = io.WriteString(ui.w, line)
+	ui.draw()
+}
+
+// clear removes the spinners.
+func (ui *progressUI) clear() {
+	if ui.lines > 0 {
+		fmt.Fprintf(ui.w, "\x1b[%dA\x1b[J", ui.lines)
+		ui.lines = 0
+	}
+}
+
+// draw prints spinners next to the running commands, the oldest ones first.
+func (ui *progressUI) draw() {
+	paths := make([]string, 0, len(ui.running))
+	for path := range ui.running {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return ui.running[paths[i]].Before(ui.running[paths[j]])
+	})
+
+	buf := &strings.Builder{}
+	for i, path := range paths {
+		if i == maxProgressRunning {
+			fmt.Fprintf(buf, "  ... and %d more\n", len(paths)-maxProgressRunning)
+			ui.lines++
+			break
+		}
+		fmt.Fprintf(buf, "%s %s %s\n", spinnerFrames[ui.frame], path,
+			time.Since(ui.running[path]).Truncate(progressInterval))
+		ui.lines++
+	}
+	_, _ = io.WriteString(ui.w, buf.String())
+}
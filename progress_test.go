@@ -0,0 +1,53 @@
+package build
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestProgressUI(t *testing.T) {
+	buf := &bytes.Buffer{}
+	ui := newProgressUI(buf)
+	now := time.Now()
+
+	ui.CommandStarted(CommandEvent{Path: "a", Time: now})
+	ui.CommandStarted(CommandEvent{Path: "b", Time: now.Add(time.Millisecond)})
+	assert.Equal(t, "⠋ a 0s\n\x1b[1A\x1b[J⠋ a 0s\n⠋ b 0s\n", buf.String())
+	assert.Equal(t, 2, ui.lines)
+
+	buf.Reset()
+	_, _ = ui.Write([]byte("[a] output\n"))
+	assert.Equal(t, "\x1b[2A\x1b[J[a] output\n⠋ a 0s\n⠋ b 0s\n", buf.String())
+
+	buf.Reset()
+	ui.LogWritten(zapcore.Entry{Level: zapcore.InfoLevel, Message: "hidden"}, "a", nil)
+	assert.Empty(t, buf.String())
+
+	ui.LogWritten(zapcore.Entry{Level: zapcore.WarnLevel, Message: "warning"}, "a", map[string]any{"count": 1})
+	assert.Equal(t, "\x1b[2A\x1b[J[a] \x1b[33mWARN\x1b[0m warning count=1\n⠋ a 0s\n⠋ b 0s\n", buf.String())
+
+	buf.Reset()
+	ui.CommandSucceeded(CommandEvent{Path: "a", Duration: 1500 * time.Millisecond})
+	assert.Equal(t, "\x1b[2A\x1b[J\x1b[32m✓\x1b[0m a 1.5s\n⠋ b 0s\n", buf.String())
+
+	buf.Reset()
+	ui.CommandFailed(CommandEvent{Path: "b", Duration: time.Second, Err: errors.New("failed")})
+	assert.Equal(t, "\x1b[1A\x1b[J\x1b[31m✗ b 1s: failed\x1b[0m\n", buf.String())
+	assert.Zero(t, ui.lines)
+}
+
+func TestProgressUIStop(t *testing.T) {
+	buf := &bytes.Buffer{}
+	ui := newProgressUI(buf)
+	ui.Start()
+	ui.CommandStarted(CommandEvent{Path: "a", Time: time.Now()})
+	ui.Stop()
+
+	assert.Zero(t, ui.lines)
+	assert.Contains(t, buf.String(), "a 0s\n\x1b[1A\x1b[J")
+}
@@ -0,0 +1,282 @@
+package build
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+
+	"github.com/outofforest/build/v2/pkg/tools"
+	"github.com/outofforest/build/v2/pkg/types"
+	"github.com/outofforest/logger"
+)
+
+const (
+	maxActionSize = 1024
+
+	// remoteCacheTimeout is the maximum time of a single request sent to remote cache, including
+	// the transfer of the content.
+	remoteCacheTimeout = 5 * time.Minute
+
+	// remoteCacheResponseTimeout is the maximum time of waiting for the response of remote cache.
+	remoteCacheResponseTimeout = 30 * time.Second
+)
+
+var hashRegexp = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// RemoteCache is the backend sharing outputs of commands between machines.
+// Action entries map cache keys to checksums of the content, content entries store archived outputs.
+type RemoteCache interface {
+	// Action returns the checksum of the content stored under the key.
+	Action(ctx context.Context, key string) (string, bool, error)
+
+	// StoreAction stores the checksum of the content under the key.
+	StoreAction(ctx context.Context, key, checksum string) error
+
+	// Content returns the content identified by the checksum.
+	Content(ctx context.Context, checksum string) (io.ReadCloser, bool, error)
+
+	// StoreContent stores the content identified by the checksum.
+	StoreContent(ctx context.Context, checksum string, content io.Reader, size int64) error
+}
+
+// NewHTTPCache returns remote cache using HTTP protocol compatible with the one used by Bazel and Gradle:
+// action entries are available under `<url>/ac/<key>`, content entries under `<url>/cas/<hash>`.
+func NewHTTPCache(url string) RemoteCache {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = remoteCacheResponseTimeout
+	return httpCache{
+		url: strings.TrimSuffix(url, "/"),
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   remoteCacheTimeout,
+		},
+	}
+}
+
+type httpCache struct {
+	url    string
+	client *http.Client
+}
+
+// Action returns the checksum of the content stored under the key.
+func (c httpCache) Action(ctx context.Context, key string) (string, bool, error) {
+	body, exists, err := c.get(ctx, "/ac/"+key)
+	if err != nil || !exists {
+		return "", false, err
+	}
+	defer body.Close()
+
+	checksum, err := io.ReadAll(io.LimitReader(body, maxActionSize))
+	if err != nil {
+		return "", false, errors.WithStack(err)
+	}
+	return string(checksum), true, nil
+}
+
+// StoreAction stores the checksum of the content under the key.
+func (c httpCache) StoreAction(ctx context.Context, key, checksum string) error {
+	return c.put(ctx, "/ac/"+key, strings.NewReader(checksum), int64(len(checksum)))
+}
+
+// Content returns the content identified by the checksum.
+func (c httpCache) Content(ctx context.Context, checksum string) (io.ReadCloser, bool, error) {
+	return c.get(ctx, "/cas/"+strings.TrimPrefix(checksum, "sha256:"))
+}
+
+// StoreContent stores the content identified by the checksum.
+func (c httpCache) StoreContent(ctx context.Context, checksum string, content io.Reader, size int64) error {
+	return c.put(ctx, "/cas/"+strings.TrimPrefix(checksum, "sha256:"), content, size)
+}
+
+func (c httpCache) get(ctx context.Context, path string) (io.ReadCloser, bool, error) {
+	resp, err := c.client.Do(lo.Must(http.NewRequestWithContext(ctx, http.MethodGet, c.url+path, nil)))
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, true, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, false, nil
+	default:
+		resp.Body.Close()
+		return nil, false, errors.Errorf("fetching %s from remote cache failed with status %s", path, resp.Status)
+	}
+}
+
+func (c httpCache) put(ctx context.Context, path string, body io.Reader, size int64) error {
+	req := lo.Must(http.NewRequestWithContext(ctx, http.MethodPut, c.url+path, body))
+	req.ContentLength = size
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("storing %s in remote cache failed with status %s", path, resp.Status)
+	}
+	return nil
+}
+
+// fetchFromRemoteCache copies the entry stored under the key from remote cache to the local one.
+func fetchFromRemoteCache(ctx context.Context, remote RemoteCache, local *localCache, key string) (bool, error) {
+	checksum, exists, err := remote.Action(ctx, key)
+	if err != nil || !exists {
+		return false, err
+	}
+
+	content, exists, err := remote.Content(ctx, checksum)
+	if err != nil || !exists {
+		return false, err
+	}
+	defer content.Close()
+
+	if err := local.Import(key, checksum, content); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// pushToRemoteCache copies the entry stored under the key from local cache to the remote one.
+func pushToRemoteCache(ctx context.Context, remote RemoteCache, local *localCache, key, checksum string) error {
+	if _, exists, err := remote.Action(ctx, key); err != nil || exists {
+		return err
+	}
+
+	content, exists, err := local.OpenContent(checksum)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.Errorf("content %s does not exist in local cache", checksum)
+	}
+	defer content.Close()
+
+	info, err := content.Stat()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := remote.StoreContent(ctx, checksum, content, info.Size()); err != nil {
+		return err
+	}
+	return remote.StoreAction(ctx, key, checksum)
+}
+
+func serveCache(ctx context.Context, _ types.DepsFunc) error {
+	if IsDryRun(ctx) {
+		return nil
+	}
+
+	address := StringFlag(ctx, "address")
+	dir := filepath.Join(tools.EnvDir(ctx), "cache-server")
+	server := &http.Server{
+		Addr:              address,
+		Handler:           newCacheHandler(newLocalCache(dir, defaultCacheMaxSize)),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	logger.Get(ctx).Info("Serving build cache", zap.String("address", address),
+		zap.String("path", dir))
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return errors.WithStack(err)
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			return errors.WithStack(err)
+		}
+		return errors.WithStack(ctx.Err())
+	}
+}
+
+// newCacheHandler returns HTTP handler serving the remote cache backed by the local directory.
+func newCacheHandler(cache *localCache) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ac/{key}", func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("key")
+		if !hashRegexp.MatchString(key) {
+			http.Error(w, "invalid key", http.StatusBadRequest)
+			return
+		}
+		checksum, exists, err := cache.Action(key)
+		switch {
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		case !exists:
+			http.NotFound(w, r)
+		default:
+			_, _ = w.Write([]byte(checksum))
+		}
+	})
+	mux.HandleFunc("PUT /ac/{key}", func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("key")
+		if !hashRegexp.MatchString(key) {
+			http.Error(w, "invalid key", http.StatusBadRequest)
+			return
+		}
+		checksum, err := io.ReadAll(io.LimitReader(r.Body, maxActionSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !strings.HasPrefix(string(checksum), "sha256:") ||
+			!hashRegexp.MatchString(strings.TrimPrefix(string(checksum), "sha256:")) {
+			http.Error(w, "invalid checksum", http.StatusBadRequest)
+			return
+		}
+		if err := cache.StoreAction(key, string(checksum)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("GET /cas/{hash}", func(w http.ResponseWriter, r *http.Request) {
+		hash := r.PathValue("hash")
+		if !hashRegexp.MatchString(hash) {
+			http.Error(w, "invalid hash", http.StatusBadRequest)
+			return
+		}
+		f, exists, err := cache.OpenContent("sha256:" + hash)
+		switch {
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		case !exists:
+			http.NotFound(w, r)
+		default:
+			defer f.Close()
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = io.Copy(w, f)
+		}
+	})
+	mux.HandleFunc("PUT /cas/{hash}", func(w http.ResponseWriter, r *http.Request) {
+		hash := r.PathValue("hash")
+		if !hashRegexp.MatchString(hash) {
+			http.Error(w, "invalid hash", http.StatusBadRequest)
+			return
+		}
+		if err := cache.ImportContent("sha256:"+hash, r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	return mux
+}
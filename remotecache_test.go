@@ -0,0 +1,98 @@
+package build
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/outofforest/build/v2/pkg/tools"
+	"github.com/outofforest/build/v2/pkg/types"
+)
+
+func TestOutputsAreRestoredFromRemoteCache(t *testing.T) {
+	server := httptest.NewServer(newCacheHandler(newLocalCache(t.TempDir(), defaultCacheMaxSize)))
+	t.Cleanup(server.Close)
+
+	t.Chdir(t.TempDir())
+	ctx := tools.WithVersion(tools.WithName(tCtx, "test"), "v1")
+
+	var executed int
+	commands := map[string]types.Command{
+		"cmd": {
+			Fn: func(_ context.Context, _ types.DepsFunc) error {
+				executed++
+				return os.WriteFile("output.txt", []byte("output"), 0o600)
+			},
+			Inputs:  []string{"input.txt"},
+			Outputs: []string{"output.txt"},
+		},
+	}
+	require.NoError(t, os.WriteFile("input.txt", []byte("input"), 0o600))
+
+	// Each execution uses fresh local cache, as if it was done on another machine.
+	exe := func() {
+		t.Setenv("XDG_CACHE_HOME", t.TempDir())
+		require.NoError(t, os.RemoveAll("output.txt"))
+		require.NoError(t, execute(ctx, commands, []string{"cmd"}, executorConfig{
+			Jobs:        1,
+			RemoteCache: NewHTTPCache(server.URL),
+		}))
+	}
+
+	exe()
+	assert.Equal(t, 1, executed)
+
+	exe()
+	assert.Equal(t, 1, executed)
+	output, err := os.ReadFile("output.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "output", string(output))
+}
+
+func TestRemoteCacheVerifiesChecksum(t *testing.T) {
+	dir := t.TempDir()
+	server := httptest.NewServer(newCacheHandler(newLocalCache(dir, defaultCacheMaxSize)))
+	t.Cleanup(server.Close)
+
+	remote := NewHTTPCache(server.URL)
+	checksum := "sha256:" + strings.Repeat("0", 64)
+
+	// Server refuses to store content not matching the checksum.
+	require.Error(t, remote.StoreContent(tCtx, checksum, strings.NewReader("content"), 7))
+
+	// Client refuses to import content not matching the checksum.
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "content"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "content", strings.Repeat("0", 64)), []byte("content"), 0o600))
+	key := strings.Repeat("1", 64)
+	require.NoError(t, remote.StoreAction(tCtx, key, checksum))
+
+	local := newLocalCache(t.TempDir(), defaultCacheMaxSize)
+	_, err := fetchFromRemoteCache(tCtx, remote, local, key)
+	require.Error(t, err)
+	_, exists, err := local.Action(key)
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestUnresponsiveRemoteCacheTimesOut(t *testing.T) {
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		<-unblock
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(unblock) })
+
+	cache := NewHTTPCache(server.URL).(httpCache)
+	cache.client.Timeout = 100 * time.Millisecond
+
+	_, _, err := cache.Action(tCtx, strings.Repeat("0", 64))
+	require.Error(t, err)
+}
@@ -0,0 +1,42 @@
+package build
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// Provide publishes the result of the command, so it may be received by dependents using `Require`.
+// Results are stored for the time of the execution and each of them may be provided once.
+func Provide[T any](ctx context.Context, name string, value T) error {
+	e := getExecutor(ctx)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, exists := e.results[name]; exists {
+		return errors.Errorf("build: result %s has already been provided", name)
+	}
+	e.results[name] = value
+	return nil
+}
+
+// Require returns the result published by the dependency using `Provide`.
+// It must be called after the dependency providing the result has been executed.
+func Require[T any](ctx context.Context, name string) (T, error) {
+	e := getExecutor(ctx)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var value T
+	result, exists := e.results[name]
+	if !exists {
+		return value, errors.Errorf("build: result %s has never been provided", name)
+	}
+	value, ok := result.(T)
+	if !ok {
+		return value, errors.Errorf("build: result %s is of type %T, not %T", name, result, value)
+	}
+	return value, nil
+}
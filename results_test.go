@@ -0,0 +1,74 @@
+package build
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/outofforest/build/v2/pkg/types"
+)
+
+func cmdVersion(ctx context.Context, _ types.DepsFunc) error {
+	return Provide(ctx, "version", "v1.2.3")
+}
+
+func TestResultIsPassedToDependent(t *testing.T) {
+	var version string
+	err := execute(tCtx, map[string]types.Command{
+		"release": {Fn: func(ctx context.Context, deps types.DepsFunc) error {
+			deps(cmdVersion)
+
+			var err error
+			version, err = Require[string](ctx, "version")
+			return err
+		}},
+	}, []string{"release"}, executorConfig{Jobs: 4})
+	require.NoError(t, err)
+	assert.Equal(t, "v1.2.3", version)
+}
+
+func TestMissingResult(t *testing.T) {
+	err := execute(tCtx, map[string]types.Command{
+		"release": {Fn: func(ctx context.Context, _ types.DepsFunc) error {
+			_, err := Require[string](ctx, "version")
+			return err
+		}},
+	}, []string{"release"}, executorConfig{Jobs: 4})
+	require.EqualError(t, err, "build: result version has never been provided")
+}
+
+func TestResultOfWrongType(t *testing.T) {
+	err := execute(tCtx, map[string]types.Command{
+		"release": {Fn: func(ctx context.Context, deps types.DepsFunc) error {
+			deps(cmdVersion)
+
+			_, err := Require[int](ctx, "version")
+			return err
+		}},
+	}, []string{"release"}, executorConfig{Jobs: 4})
+	require.EqualError(t, err, "build: result version is of type string, not int")
+}
+
+func TestResultProvidedTwice(t *testing.T) {
+	err := execute(tCtx, map[string]types.Command{
+		"release": {Fn: func(ctx context.Context, deps types.DepsFunc) error {
+			deps(cmdVersion)
+			return Provide(ctx, "version", "v2.0.0")
+		}},
+	}, []string{"release"}, executorConfig{Jobs: 4})
+	require.EqualError(t, err, "build: result version has already been provided")
+}
+
+func TestResultsAreTiedToExecution(t *testing.T) {
+	commands := map[string]types.Command{
+		"version": {Fn: cmdVersion},
+		"release": {Fn: func(ctx context.Context, _ types.DepsFunc) error {
+			_, err := Require[string](ctx, "version")
+			return err
+		}},
+	}
+	require.NoError(t, execute(tCtx, commands, []string{"version"}, executorConfig{Jobs: 4}))
+	require.Error(t, execute(tCtx, commands, []string{"release"}, executorConfig{Jobs: 4}))
+}
@@ -0,0 +1,94 @@
+package build
+
+import (
+	"path"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/build/v2/pkg/types"
+)
+
+// recursiveWildcard matches all the commands under the path preceding it.
+const recursiveWildcard = "..."
+
+// isPattern returns true if the path selects many commands, e.g. `test/*` or `lint/...`.
+func isPattern(p string) bool {
+	return p == recursiveWildcard || strings.HasSuffix(p, "/"+recursiveWildcard) || strings.ContainsAny(p, "*?[")
+}
+
+// expandPaths replaces patterns with sorted paths of matching commands. `*` matches any sequence of characters
+// within single segment of the path, `...` matches all the commands under the path. Hidden and interactive
+// commands are not matched, neither are the standard ones, because some of them never complete.
+// Other paths are returned unchanged.
+func expandPaths(commands map[string]types.Command, paths []string) ([]string, error) {
+	expanded := make([]string, 0, len(paths))
+	for _, p := range paths {
+		if !isPattern(p) {
+			expanded = append(expanded, p)
+			continue
+		}
+
+		matches, err := matchPaths(commands, p)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, errors.Errorf("build: no command matches %s", p)
+		}
+		expanded = append(expanded, matches...)
+	}
+	return expanded, nil
+}
+
+func matchPaths(commands map[string]types.Command, pattern string) ([]string, error) {
+	matches := []string{}
+	if pattern == recursiveWildcard || strings.HasSuffix(pattern, "/"+recursiveWildcard) {
+		prefix := strings.TrimSuffix(pattern, recursiveWildcard)
+		for _, p := range selectablePaths(commands) {
+			if strings.HasPrefix(p, prefix) {
+				matches = append(matches, p)
+			}
+		}
+		return matches, nil
+	}
+
+	for _, p := range selectablePaths(commands) {
+		matched, err := path.Match(pattern, p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "build: invalid pattern %s", pattern)
+		}
+		if matched {
+			matches = append(matches, p)
+		}
+	}
+	return matches, nil
+}
+
+// standardCommands contains functions of the standard commands. It is filled in init, because standard commands
+// refer to the code expanding patterns.
+var standardCommands = map[uintptr]bool{}
+
+func init() {
+	for _, cmd := range Commands {
+		standardCommands[reflect.ValueOf(cmd.Fn).Pointer()] = true
+	}
+}
+
+// selectablePaths returns sorted paths of commands which may be selected by patterns.
+func selectablePaths(commands map[string]types.Command) []string {
+	selectable := []string{}
+	for _, p := range visiblePaths(commands) {
+		cmd := commands[p]
+		if !cmd.Interactive && !isStandardCommand(cmd) {
+			selectable = append(selectable, p)
+		}
+	}
+	return selectable
+}
+
+// isStandardCommand returns true if the command is one of the standard ones.
+func isStandardCommand(cmd types.Command) bool {
+	return cmd.Fn != nil && standardCommands[reflect.ValueOf(cmd.Fn).Pointer()]
+}
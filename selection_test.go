@@ -0,0 +1,106 @@
+package build
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/outofforest/build/v2/pkg/types"
+)
+
+var selectionCommands = map[string]types.Command{
+	"lint":               {},
+	"lint/go":            {},
+	"lint/go/imports":    {},
+	"lint/yaml":          {},
+	"lint/internal":      {Hidden: true},
+	"test/integration":   {},
+	"test/unit":          {},
+	"test/unit/coverage": {},
+}
+
+func TestExpandPaths(t *testing.T) {
+	expanded, err := expandPaths(selectionCommands, []string{"test/*"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"test/integration", "test/unit"}, expanded)
+
+	expanded, err = expandPaths(selectionCommands, []string{"lint/..."})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"lint/go", "lint/go/imports", "lint/yaml"}, expanded)
+
+	expanded, err = expandPaths(selectionCommands, []string{"test/unit", "lint/*o", "..."})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"test/unit", "lint/go", "lint", "lint/go", "lint/go/imports", "lint/yaml", "test/integration", "test/unit",
+		"test/unit/coverage",
+	}, expanded)
+
+	_, err = expandPaths(selectionCommands, []string{"build/..."})
+	require.EqualError(t, err, "build: no command matches build/...")
+
+	_, err = expandPaths(selectionCommands, []string{"test/["})
+	require.EqualError(t, err, "build: invalid pattern test/[: syntax error in pattern")
+}
+
+func TestExecutePattern(t *testing.T) {
+	var mu sync.Mutex
+	executed := []string{}
+	record := func(path string) types.CommandFunc {
+		return func(_ context.Context, _ types.DepsFunc) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			executed = append(executed, path)
+			return nil
+		}
+	}
+
+	require.NoError(t, execute(tCtx, map[string]types.Command{
+		"lint/yaml": {Fn: record("lint/yaml")},
+		"lint/go":   {Fn: record("lint/go")},
+		"lint/sh":   {Fn: record("lint/sh")},
+		"test":      {Fn: record("test")},
+	}, []string{"lint/..."}, executorConfig{Jobs: 4}))
+	assert.Equal(t, []string{"lint/go", "lint/sh", "lint/yaml"}, executed)
+}
+
+func TestParseArgsWithPattern(t *testing.T) {
+	paths, commandFlags, err := parseArgs(globalFlags(), map[string]types.Command{
+		"deploy":  {Flags: []types.Flag{{Name: "env", Default: "dev"}}},
+		"lint/go": {},
+	}, []string{"lint/*", "-j", "2", "deploy", "--env=staging"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"lint/*", "deploy"}, paths)
+	assert.Equal(t, "staging", lo.Must(commandFlags["deploy"].GetString("env")))
+	assert.NotContains(t, commandFlags, "lint/*")
+
+	_, _, err = parseArgs(globalFlags(), map[string]types.Command{
+		"deploy": {Flags: []types.Flag{{Name: "env", Default: "dev"}}},
+	}, []string{"deploy/...", "--env=staging"})
+	require.EqualError(t, err, "unknown flag: --env")
+}
+
+func TestPatternsSkipInteractiveAndStandardCommands(t *testing.T) {
+	commands := map[string]types.Command{
+		"lint/go":    {Fn: cmdA},
+		"lint/shell": {Fn: cmdB, Interactive: true},
+	}
+	for path, cmd := range Commands {
+		commands[path] = cmd
+	}
+
+	expanded, err := expandPaths(commands, []string{"..."})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"lint/go"}, expanded)
+
+	_, err = expandPaths(commands, []string{"cache/*"})
+	require.EqualError(t, err, "build: no command matches cache/*")
+
+	expanded, err = expandPaths(commands, []string{"cache/serve", "lint/shell"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cache/serve", "lint/shell"}, expanded)
+}
@@ -0,0 +1,138 @@
+package build
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/outofforest/build/v2/pkg/types"
+)
+
+const maxSlowestCommands = 10
+
+// commandTiming stores the time spent on executing the command.
+type commandTiming struct {
+	Path string
+	// Exclusive is the time spent on executing the command, excluding its dependencies.
+	Exclusive time.Duration
+	// Inclusive is the time spent on executing the command, including its dependencies.
+	Inclusive time.Duration
+}
+
+// Timings returns timings of completed commands, sorted from the slowest one.
+func (e *executor) Timings() []commandTiming {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	timings := []commandTiming{}
+	for _, inv := range e.invocationsInOrder() {
+		if !inv.isDone() {
+			continue
+		}
+		timings = append(timings, commandTiming{
+			Path:      inv.name,
+			Exclusive: inv.exclusiveDuration(),
+			Inclusive: inv.inclusiveDuration(),
+		})
+	}
+	sort.SliceStable(timings, func(i, j int) bool {
+		return timings[i].Exclusive > timings[j].Exclusive
+	})
+	return timings
+}
+
+// CriticalPath returns the chain of dependent commands taking the longest time to execute.
+func (e *executor) CriticalPath() []commandTiming {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	type pathInfo struct {
+		duration time.Duration
+		next     *invocation
+	}
+
+	paths := map[*invocation]pathInfo{}
+	var head *invocation
+	for _, inv := range e.invocationsInOrder() {
+		if !inv.isDone() {
+			continue
+		}
+		info := pathInfo{duration: inv.exclusiveDuration()}
+		var longest time.Duration
+		for _, dep := range inv.deps {
+			if depInfo, exists := paths[dep]; exists && (info.next == nil || depInfo.duration > longest) {
+				longest = depInfo.duration
+				info.next = dep
+			}
+		}
+		info.duration += longest
+		paths[inv] = info
+
+		if head == nil || info.duration > paths[head].duration {
+			head = inv
+		}
+	}
+
+	criticalPath := []commandTiming{}
+	for inv := head; inv != nil; inv = paths[inv].next {
+		criticalPath = append(criticalPath, commandTiming{
+			Path:      inv.name,
+			Exclusive: inv.exclusiveDuration(),
+			Inclusive: inv.inclusiveDuration(),
+		})
+	}
+	return criticalPath
+}
+
+// showTimings returns true if the summary of timings is worth printing. It is not, if single command was executed,
+// or interactive or standard command was requested.
+func showTimings(commands map[string]types.Command, paths []string, timings []commandTiming) bool {
+	if len(timings) < 2 {
+		return false
+	}
+	for _, path := range paths {
+		if _, cmd, _ := lookupCommand(commands, path); cmd.Interactive || isStandardCommand(cmd) {
+			return false
+		}
+	}
+	return true
+}
+
+func printTimings(w io.Writer, timings, criticalPath []commandTiming) {
+	if len(timings) == 0 {
+		return
+	}
+	if len(timings) > maxSlowestCommands {
+		timings = timings[:maxSlowestCommands]
+	}
+
+	var maxLen int
+	for _, timing := range timings {
+		if len(timing.Path) > maxLen {
+			maxLen = len(timing.Path)
+		}
+	}
+	fmt.Fprintln(w, "\n Slowest commands:")
+	fmt.Fprintln(w)
+	for _, timing := range timings {
+		fmt.Fprintf(w, fmt.Sprintf(`   %%-%ds`, maxLen)+"  %10s  (with dependencies: %s)\n", timing.Path,
+			roundDuration(timing.Exclusive), roundDuration(timing.Inclusive))
+	}
+
+	var total time.Duration
+	steps := make([]string, 0, len(criticalPath))
+	for i := len(criticalPath) - 1; i >= 0; i-- {
+		total += criticalPath[i].Exclusive
+		steps = append(steps, criticalPath[i].Path)
+	}
+	fmt.Fprintf(w, "\n Critical path (%s):\n", roundDuration(total))
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "   %s\n", strings.Join(steps, " -> "))
+	fmt.Fprintln(w)
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	return d.Round(time.Millisecond)
+}
@@ -0,0 +1,154 @@
+package build
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const tracePID = 1
+
+// traceEvent is the event defined by Chrome Trace Event Format.
+type traceEvent struct {
+	Name      string         `json:"name"`
+	Category  string         `json:"cat,omitempty"`
+	Phase     string         `json:"ph"`
+	Timestamp float64        `json:"ts"`
+	Duration  float64        `json:"dur,omitempty"`
+	PID       int            `json:"pid"`
+	TID       int            `json:"tid"`
+	Scope     string         `json:"s,omitempty"`
+	Args      map[string]any `json:"args,omitempty"`
+}
+
+// Trace returns events describing the execution of completed commands.
+// Each command is reported as a single span nested under the span of the command which requested it.
+// Moments when command takes a lane are reported as instant events.
+func (e *executor) Trace() []traceEvent {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	events := []traceEvent{
+		{
+			Name:  "process_name",
+			Phase: "M",
+			PID:   tracePID,
+			Args:  map[string]any{"name": "build"},
+		},
+	}
+
+	invs := []*invocation{}
+	for _, inv := range e.invocationsInOrder() {
+		if inv.isDone() && len(inv.segments) > 0 {
+			invs = append(invs, inv)
+		}
+	}
+	sort.SliceStable(invs, func(i, j int) bool {
+		return invs[i].started.Before(invs[j].started)
+	})
+
+	// Spans put on the same track are nested, so command is put on the track of the command which requested it,
+	// if it is possible. Otherwise, the first track where it doesn't overlap with spans of other commands is used.
+	tracks := [][]*invocation{}
+	tids := map[*invocation]int{}
+	for _, inv := range invs {
+		tid, exists := tids[inv.parent]
+		if !exists || !fitsTrack(tracks[tid], inv) {
+			tid = len(tracks)
+			for i, track := range tracks {
+				if fitsTrack(track, inv) {
+					tid = i
+					break
+				}
+			}
+			if tid == len(tracks) {
+				tracks = append(tracks, nil)
+			}
+		}
+		tracks[tid] = append(tracks[tid], inv)
+		tids[inv] = tid
+
+		args := map[string]any{
+			"duration":         inv.exclusiveDuration().String(),
+			"durationWithDeps": inv.inclusiveDuration().String(),
+		}
+		if inv.parent != e.root {
+			args["requestedBy"] = inv.parent.name
+		}
+		if inv.err != nil {
+			args["error"] = inv.err.Error()
+		}
+
+		events = append(events, traceEvent{
+			Name:      inv.name,
+			Category:  "command",
+			Phase:     "X",
+			Timestamp: e.traceTimestamp(inv.started),
+			Duration:  float64(inv.finished.Sub(inv.started)) / float64(time.Microsecond),
+			PID:       tracePID,
+			TID:       tid,
+			Args:      args,
+		})
+		for _, s := range inv.segments {
+			events = append(events, traceEvent{
+				Name:      "lane " + strconv.Itoa(s.Lane+1),
+				Category:  "lane",
+				Phase:     "i",
+				Timestamp: e.traceTimestamp(s.Start),
+				PID:       tracePID,
+				TID:       tid,
+				Scope:     "t",
+				Args:      map[string]any{"command": inv.name, "lane": s.Lane + 1},
+			})
+		}
+	}
+	return events
+}
+
+// fitsTrack returns true if span of the invocation may be put on the track without breaking the nesting,
+// meaning that every span overlapping with it belongs to its ancestor and contains it.
+func fitsTrack(track []*invocation, inv *invocation) bool {
+	for _, other := range track {
+		if !other.started.Before(inv.finished) || !inv.started.Before(other.finished) {
+			continue
+		}
+		if inv.started.Before(other.started) || inv.finished.After(other.finished) || !inv.requestedBy(other) {
+			return false
+		}
+	}
+	return true
+}
+
+// requestedBy returns true if the invocation was requested by the other one, directly or indirectly.
+func (inv *invocation) requestedBy(other *invocation) bool {
+	for parent := inv.parent; parent != nil; parent = parent.parent {
+		if parent == other {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *executor) traceTimestamp(t time.Time) float64 {
+	return float64(t.Sub(e.started)) / float64(time.Microsecond)
+}
+
+func writeTrace(file string, events []traceEvent) error {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+
+	return errors.WithStack(json.NewEncoder(f).Encode(struct {
+		TraceEvents     []traceEvent `json:"traceEvents"`
+		DisplayTimeUnit string       `json:"displayTimeUnit"`
+	}{
+		TraceEvents:     events,
+		DisplayTimeUnit: "ms",
+	}))
+}
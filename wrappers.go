@@ -0,0 +1,156 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/outofforest/build/v2/pkg/types"
+)
+
+var defaultWrapperRegistry = newWrapperRegistry()
+
+// commandOptions are the options attached to the command function by wrappers.
+type commandOptions struct {
+	Timeout time.Duration
+}
+
+// merge returns options overridden by the other ones.
+func (o commandOptions) merge(o2 commandOptions) commandOptions {
+	if o2.Timeout != 0 {
+		o.Timeout = o2.Timeout
+	}
+	return o
+}
+
+type wrapperKey struct {
+	fn      reflect.Value
+	options commandOptions
+}
+
+type wrapper struct {
+	fn      types.CommandFunc
+	options commandOptions
+}
+
+type bindingKey struct {
+	fn  reflect.Value
+	key any
+}
+
+func newWrapperRegistry() *wrapperRegistry {
+	return &wrapperRegistry{
+		wrappers: map[wrapperKey]types.CommandFunc{},
+		wrapped:  map[reflect.Value]wrapper{},
+		bindings: map[bindingKey]types.CommandFunc{},
+		names:    map[reflect.Value]string{},
+	}
+}
+
+// wrapperRegistry keeps track of functions wrapping commands to attach options or keys to them.
+// The same wrapper is returned every time for the same function and options or key, so executor may
+// deduplicate them.
+type wrapperRegistry struct {
+	mu       sync.Mutex
+	wrappers map[wrapperKey]types.CommandFunc
+	wrapped  map[reflect.Value]wrapper
+	bindings map[bindingKey]types.CommandFunc
+	names    map[reflect.Value]string
+}
+
+// Wrap returns the function wrapping the command and attaching options to it.
+func (wr *wrapperRegistry) Wrap(
+	fn types.CommandFunc,
+	options commandOptions,
+	wrapFn func(fn types.CommandFunc) types.CommandFunc,
+) types.CommandFunc {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	if w, exists := wr.wrapped[reflect.ValueOf(fn)]; exists {
+		fn = w.fn
+		options = w.options.merge(options)
+	}
+
+	key := wrapperKey{fn: reflect.ValueOf(fn), options: options}
+	if wrapped, exists := wr.wrappers[key]; exists {
+		return wrapped
+	}
+
+	wrapped := wrapFn(fn)
+	wr.wrappers[key] = wrapped
+	wr.wrapped[reflect.ValueOf(wrapped)] = wrapper{fn: fn, options: options}
+	return wrapped
+}
+
+// Unwrap returns the wrapped command and options attached to it.
+func (wr *wrapperRegistry) Unwrap(fn types.CommandFunc) (types.CommandFunc, commandOptions) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	if w, exists := wr.wrapped[reflect.ValueOf(fn)]; exists {
+		return w.fn, w.options
+	}
+	return fn, commandOptions{}
+}
+
+// Bind returns the command function calling fn with the key.
+func (wr *wrapperRegistry) Bind(fn any, key any, bindFn func() types.CommandFunc) types.CommandFunc {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	fnValue := reflect.ValueOf(fn)
+	bKey := bindingKey{fn: fnValue, key: key}
+	if bound, exists := wr.bindings[bKey]; exists {
+		return bound
+	}
+
+	bound := bindFn()
+	wr.bindings[bKey] = bound
+	wr.names[reflect.ValueOf(bound)] = fmt.Sprintf("%s(%v)", funcName(fnValue), key)
+	return bound
+}
+
+// Name returns the name of the command function bound to the key.
+func (wr *wrapperRegistry) Name(fn reflect.Value) (string, bool) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	name, exists := wr.names[fn]
+	return name, exists
+}
+
+// Dep returns the command function calling fn with the key. Dependencies are deduplicated by function and key,
+// so fn is executed once for each key.
+func Dep[K comparable](fn func(ctx context.Context, deps types.DepsFunc, key K) error, key K) types.CommandFunc {
+	return defaultWrapperRegistry.Bind(fn, key, func() types.CommandFunc {
+		return func(ctx context.Context, deps types.DepsFunc) error {
+			return fn(ctx, deps, key)
+		}
+	})
+}
+
+// WithTimeout returns command function which is canceled if it does not complete within the timeout.
+// If the same function is requested many times with different timeouts, it is executed once,
+// with the timeout specified by the first request.
+func WithTimeout(fn types.CommandFunc, timeout time.Duration) types.CommandFunc {
+	return defaultWrapperRegistry.Wrap(fn, commandOptions{Timeout: timeout},
+		func(fn types.CommandFunc) types.CommandFunc {
+			return func(ctx context.Context, deps types.DepsFunc) error {
+				ctx, cancel := context.WithTimeout(ctx, timeout)
+				defer cancel()
+
+				return fn(ctx, deps)
+			}
+		})
+}
+
+func funcName(fn reflect.Value) string {
+	if f := runtime.FuncForPC(fn.Pointer()); f != nil {
+		return f.Name()
+	}
+	return "<unknown>"
+}